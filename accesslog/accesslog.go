@@ -0,0 +1,251 @@
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/junlongzzz/file-download-agent/common"
+)
+
+// Format 访问日志输出格式
+type Format string
+
+const (
+	FormatJSON     Format = "json"     // 每行一个 JSON 对象
+	FormatLogfmt   Format = "logfmt"   // key=value 格式
+	FormatCombined Format = "combined" // Apache combined 格式
+)
+
+// ParseFormat 解析访问日志格式
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(s))); f {
+	case FormatJSON, FormatLogfmt, FormatCombined:
+		return f, nil
+	case "":
+		return FormatJSON, nil
+	default:
+		return "", fmt.Errorf("unknown access log format: %s", s)
+	}
+}
+
+// Options 访问日志配置
+type Options struct {
+	Enable     bool          // 是否启用
+	Format     Format        // 输出格式
+	File       string        // 日志文件路径，为空时输出到标准输出
+	MaxSize    int64         // 单个日志文件最大字节数，超过后轮转，<=0 时不按大小轮转
+	MaxAge     time.Duration // 单个日志文件最长写入时间，超过后轮转，<=0 时不按时间轮转
+	MaxBackups int           // 保留的历史日志文件数，<=0 时全部保留
+}
+
+// Entry 一条访问日志
+type Entry struct {
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	Route     string        `json:"route"`
+	Path      string        `json:"path"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"-"`
+	ClientIP  string        `json:"client_ip"`
+	User      string        `json:"user,omitempty"`
+	UserAgent string        `json:"user_agent"`
+	Referer   string        `json:"referer,omitempty"`
+	LinkID    string        `json:"link_id,omitempty"`
+	Upstream  string        `json:"upstream,omitempty"`
+}
+
+// 请求处理过程中由 handler 补充的字段
+type annotations struct {
+	mu       sync.Mutex
+	linkID   string
+	upstream string
+}
+
+type contextKey struct{}
+
+// SetLinkID 记录本次请求访问的下载链接标识
+func SetLinkID(ctx context.Context, linkID string) {
+	if a, ok := ctx.Value(contextKey{}).(*annotations); ok {
+		a.mu.Lock()
+		a.linkID = linkID
+		a.mu.Unlock()
+	}
+}
+
+// SetUpstream 记录本次请求访问的上游主机
+func SetUpstream(ctx context.Context, host string) {
+	if a, ok := ctx.Value(contextKey{}).(*annotations); ok {
+		a.mu.Lock()
+		a.upstream = host
+		a.mu.Unlock()
+	}
+}
+
+// Logger 访问日志记录器，配置可热更新，零值为未启用
+type Logger struct {
+	mu     sync.Mutex // 写入与切换输出时加锁
+	format Format     // 输出格式
+	out    io.Writer  // 当前输出，文件输出时为 *rotateWriter，未启用时为 nil
+}
+
+// SetOptions 更新日志配置，打开新的输出成功后才关闭旧的输出
+func (l *Logger) SetOptions(opts Options) error {
+	apply, err := l.Prepare(opts)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// Prepare 校验配置并打开日志文件，返回应用配置的函数，失败时不修改当前配置
+func (l *Logger) Prepare(opts Options) (func(), error) {
+	format, err := ParseFormat(string(opts.Format))
+	if err != nil {
+		return nil, err
+	}
+	var out io.Writer
+	switch {
+	case !opts.Enable:
+	case opts.File == "":
+		out = os.Stdout
+	default:
+		rw, err := newRotateWriter(opts.File, opts.MaxSize, opts.MaxAge, opts.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out = rw
+	}
+	return func() {
+		l.mu.Lock()
+		old := l.out
+		l.out = out
+		l.format = format
+		l.mu.Unlock()
+		if rw, ok := old.(*rotateWriter); ok {
+			_ = rw.Close()
+		}
+	}, nil
+}
+
+// Close 关闭日志文件
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rw, ok := l.out.(*rotateWriter); ok {
+		return rw.Close()
+	}
+	return nil
+}
+
+// Log 按当前格式输出一条访问日志
+func (l *Logger) Log(e *Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.out != nil {
+		_, _ = l.out.Write(format(l.format, e))
+	}
+}
+
+// Middleware 记录每个请求的访问日志，包括失败的请求
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// handler 可能改写请求路径，提前记录
+		path := r.URL.Path
+		a := &annotations{}
+		r = r.WithContext(context.WithValue(r.Context(), contextKey{}, a))
+		rec := common.NewResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		user, _, _ := r.BasicAuth()
+		a.mu.Lock()
+		e := &Entry{
+			Time:      start,
+			Method:    r.Method,
+			Route:     r.Pattern, // 由 ServeMux 在匹配路由后设置
+			Path:      path,
+			Proto:     r.Proto,
+			Status:    rec.Status(),
+			Bytes:     rec.Written(),
+			Duration:  time.Since(start),
+			ClientIP:  common.GetRealIP(r),
+			User:      user,
+			UserAgent: r.UserAgent(),
+			Referer:   r.Referer(),
+			LinkID:    a.linkID,
+			Upstream:  a.upstream,
+		}
+		a.mu.Unlock()
+		l.Log(e)
+	})
+}
+
+// 按格式序列化访问日志，返回以换行结尾的一行
+func format(f Format, e *Entry) []byte {
+	var b strings.Builder
+	switch f {
+	case FormatCombined:
+		// %h - %u [%t] "%r" %>s %b "%{Referer}i" "%{User-Agent}i"
+		bytes := "-"
+		if e.Bytes > 0 {
+			bytes = strconv.FormatInt(e.Bytes, 10)
+		}
+		fmt.Fprintf(&b, "%s - %s [%s] %s %d %s %s %s\n",
+			e.ClientIP, orDash(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			strconv.Quote(e.Method+" "+e.Path+" "+e.Proto), e.Status, bytes,
+			strconv.Quote(orDash(e.Referer)), strconv.Quote(orDash(e.UserAgent)))
+	case FormatLogfmt:
+		fmt.Fprintf(&b, "time=%s method=%s route=%s path=%s status=%d bytes=%d duration_ms=%.3f client_ip=%s",
+			e.Time.Format(time.RFC3339Nano), logfmtValue(e.Method), logfmtValue(e.Route), logfmtValue(e.Path),
+			e.Status, e.Bytes, durationMillis(e.Duration), logfmtValue(e.ClientIP))
+		if e.User != "" {
+			fmt.Fprintf(&b, " user=%s", logfmtValue(e.User))
+		}
+		fmt.Fprintf(&b, " user_agent=%s", logfmtValue(e.UserAgent))
+		if e.LinkID != "" {
+			fmt.Fprintf(&b, " link_id=%s", logfmtValue(e.LinkID))
+		}
+		if e.Upstream != "" {
+			fmt.Fprintf(&b, " upstream=%s", logfmtValue(e.Upstream))
+		}
+		b.WriteByte('\n')
+	default:
+		line, _ := json.Marshal(struct {
+			*Entry
+			DurationMs float64 `json:"duration_ms"`
+		}{e, durationMillis(e.Duration)})
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// 含空格、引号、等号或为空的值需要加引号
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=\t\r\n\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
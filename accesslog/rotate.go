@@ -0,0 +1,122 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 历史日志文件名中的时间格式
+const backupTimeFormat = "20060102-150405.000"
+
+// 按大小或时间轮转的日志文件
+// 轮转时将当前文件重命名为 <file>.<time>，并删除超出保留数量的历史文件
+type rotateWriter struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64     // 当前文件已写入的字节数
+	openedAt time.Time // 当前文件开始写入的时间
+}
+
+func newRotateWriter(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotateWriter, error) {
+	rw := &rotateWriter{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := rw.open(); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// 以追加方式打开日志文件，已存在时从文件修改时间开始计算写入时间
+func (rw *rotateWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(rw.path), 0o755); err != nil {
+		return fmt.Errorf("create access log directory: %w", err)
+	}
+	file, err := os.OpenFile(rw.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open access log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("open access log: %w", err)
+	}
+	rw.file, rw.size, rw.openedAt = file, info.Size(), time.Now()
+	if info.Size() > 0 {
+		rw.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+func (rw *rotateWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rw.size > 0 && ((rw.maxSize > 0 && rw.size+int64(len(p)) > rw.maxSize) ||
+		(rw.maxAge > 0 && time.Since(rw.openedAt) >= rw.maxAge)) {
+		if err := rw.rotate(); err != nil {
+			// 轮转失败时继续写入当前文件，避免丢失日志
+			fmt.Fprintf(os.Stderr, "access log rotate error: %v\n", err)
+		}
+	}
+	n, err := rw.file.Write(p)
+	rw.size += int64(n)
+	return n, err
+}
+
+// 轮转当前日志文件
+func (rw *rotateWriter) rotate() error {
+	if err := rw.file.Close(); err != nil {
+		return err
+	}
+	backup := rw.path + "." + time.Now().Format(backupTimeFormat)
+	renameErr := os.Rename(rw.path, backup)
+	if err := rw.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	rw.prune()
+	return nil
+}
+
+// 删除超出保留数量的历史日志文件，文件名中的时间可按字典序排序
+func (rw *rotateWriter) prune() {
+	if rw.maxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(rw.path + ".*")
+	if err != nil {
+		return
+	}
+	backups = slices.DeleteFunc(backups, func(name string) bool {
+		_, err := time.Parse(backupTimeFormat, name[len(rw.path)+1:])
+		return err != nil
+	})
+	slices.Sort(backups)
+	for len(backups) > rw.maxBackups {
+		_ = os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+func (rw *rotateWriter) Close() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.file == nil {
+		return nil
+	}
+	err := rw.file.Close()
+	rw.file = nil
+	return err
+}
@@ -0,0 +1,276 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 记录类型
+const (
+	KindDownload = "download"
+	KindWebDav   = "webdav"
+)
+
+// 分段文件名格式 audit-<首条记录ID>.jsonl，ID 补零便于按文件名排序
+const (
+	segmentPrefix = "audit-"
+	segmentSuffix = ".jsonl"
+	segmentFormat = segmentPrefix + "%020d" + segmentSuffix
+
+	// DefaultSegmentSize 默认单个分段文件的最大字节数
+	DefaultSegmentSize = 16 * 1024 * 1024
+)
+
+// Record 一条审计记录
+type Record struct {
+	ID        uint64    `json:"id"`
+	Time      time.Time `json:"time"`
+	Kind      string    `json:"kind"` // download 或 webdav
+	Method    string    `json:"method"`
+	LinkID    string    `json:"link_id,omitempty"`
+	Target    string    `json:"target"`                // 下载地址（已隐藏凭证与查询参数）或 WebDAV 路径
+	Dest      string    `json:"destination,omitempty"` // WebDAV MOVE/COPY 的目标路径
+	User      string    `json:"user,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+	UserAgent string    `json:"user_agent"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// Filter 查询条件，零值表示不过滤
+type Filter struct {
+	Kind     string
+	LinkID   string
+	User     string
+	ClientIP string
+	Target   string // 目标包含的子串
+	Status   int
+	Since    time.Time
+	Until    time.Time
+	Before   uint64 // 仅返回 ID 小于该值的记录，用于翻页
+	Limit    int
+}
+
+func (f *Filter) match(r *Record) bool {
+	return (f.Kind == "" || r.Kind == f.Kind) &&
+		(f.LinkID == "" || r.LinkID == f.LinkID) &&
+		(f.User == "" || r.User == f.User) &&
+		(f.ClientIP == "" || r.ClientIP == f.ClientIP) &&
+		(f.Target == "" || strings.Contains(r.Target, f.Target) || strings.Contains(r.Dest, f.Target)) &&
+		(f.Status == 0 || r.Status == f.Status) &&
+		(f.Since.IsZero() || !r.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || r.Time.Before(f.Until)) &&
+		(f.Before == 0 || r.ID < f.Before)
+}
+
+// Store 只追加的审计日志，按大小切分为多个 JSONL 分段文件
+type Store struct {
+	dir         string
+	segmentSize int64
+
+	mu     sync.Mutex
+	file   *os.File // 当前写入的分段
+	size   int64    // 当前分段大小
+	lastID uint64   // 最后一条记录的ID
+}
+
+// Open 打开审计日志目录，从最后一个分段恢复记录ID
+func Open(dir string, segmentSize int64) (*Store, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create audit directory: %w", err)
+	}
+	s := &Store{dir: dir, segmentSize: segmentSize}
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		if s.lastID, err = lastRecordID(last); err != nil {
+			return nil, err
+		}
+		if s.lastID == 0 {
+			// 最后一个分段为空，ID 从分段文件名恢复
+			_, _ = fmt.Sscanf(filepath.Base(last), segmentFormat, &s.lastID)
+			s.lastID = max(s.lastID, 1) - 1
+		}
+		if err = s.openSegment(last); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// 按文件名排序的所有分段，即按记录先后排序
+func (s *Store) segments() ([]string, error) {
+	segments, err := filepath.Glob(filepath.Join(s.dir, segmentPrefix+"*"+segmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(segments)
+	return segments, nil
+}
+
+func (s *Store) openSegment(name string) error {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit segment: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	if s.size > 0 {
+		// 上次写入中断留下不完整的行，补齐换行避免与新记录连在一起
+		last := make([]byte, 1)
+		if _, err = file.ReadAt(last, s.size-1); err == nil && last[0] != '\n' {
+			n, _ := file.Write([]byte("\n"))
+			s.size += int64(n)
+		}
+	}
+	return nil
+}
+
+// 读取分段中最后一条完整记录的ID，分段为空时返回 0
+func lastRecordID(name string) (uint64, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, fmt.Errorf("read audit segment: %w", err)
+	}
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		var r Record
+		if json.Unmarshal(lines[i], &r) == nil {
+			return r.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+// Append 追加一条审计记录，s 为空时忽略
+// 写入失败只记录日志，不影响请求处理
+func (s *Store) Append(r Record) {
+	if s == nil {
+		return
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.ID = s.lastID + 1
+	line, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	if s.file == nil || s.size+int64(len(line)) > s.segmentSize {
+		if s.file != nil {
+			_ = s.file.Close()
+			s.file = nil
+		}
+		if err = s.openSegment(filepath.Join(s.dir, fmt.Sprintf(segmentFormat, r.ID))); err != nil {
+			slog.Error(fmt.Sprintf("Audit log error: %v", err))
+			return
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Audit log write error: %v", err))
+		return
+	}
+	s.lastID = r.ID
+}
+
+// Query 按条件查询记录，按时间倒序返回最多 Limit 条
+// 还有更多记录时 next 为下一页的 Before 参数，否则为 0
+func (s *Store) Query(f Filter) (records []Record, next uint64, err error) {
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	// 查询过程中新追加的不完整记录会被跳过
+	segments, err := s.segments()
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		var first uint64
+		_, _ = fmt.Sscanf(filepath.Base(segments[i]), segmentFormat, &first)
+		if f.Before != 0 && first >= f.Before {
+			// 分段内的记录都不早于翻页位置
+			continue
+		}
+		matched, err := readSegment(segments[i], &f)
+		if err != nil {
+			return nil, 0, err
+		}
+		for j := len(matched) - 1; j >= 0; j-- {
+			if len(records) == f.Limit {
+				return records, records[len(records)-1].ID, nil
+			}
+			records = append(records, matched[j])
+		}
+	}
+	return records, 0, nil
+}
+
+// 读取分段中符合条件的记录，跳过写入中断造成的不完整行
+func readSegment(name string, f *Filter) ([]Record, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	var records []Record
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var r Record
+		if json.Unmarshal(line, &r) == nil && f.match(&r) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// Close 关闭当前分段
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,19 @@
+package common
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// BearerAuth 校验 Authorization: Bearer <token>，不匹配时返回 401
+func BearerAuth(token, realm string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -72,3 +73,16 @@ func GetRealIP(r *http.Request) string {
 
 	return addr
 }
+
+// RedactURL 隐藏url中的凭证与查询参数，避免签名等敏感信息泄露
+func RedactURL(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Host == "" && u.Scheme != "file") {
+		return "<redacted>"
+	}
+	redacted := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}
+	if u.RawQuery != "" {
+		redacted.RawQuery = "redacted"
+	}
+	return redacted.String()
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || windows)
+
+package common
+
+import "errors"
+
+// ErrDiskFreeUnsupported 当前平台不支持获取磁盘可用空间
+var ErrDiskFreeUnsupported = errors.New("disk free space is not supported on this platform")
+
+// DiskFree 获取路径所在磁盘的可用空间，单位：字节
+func DiskFree(path string) (uint64, error) {
+	return 0, ErrDiskFreeUnsupported
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package common
+
+import "syscall"
+
+// DiskFree 获取路径所在磁盘的可用空间，单位：字节
+func DiskFree(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
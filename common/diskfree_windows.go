@@ -0,0 +1,18 @@
+//go:build windows
+
+package common
+
+import "golang.org/x/sys/windows"
+
+// DiskFree 获取路径所在磁盘的可用空间，单位：字节
+func DiskFree(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err = windows.GetDiskFreeSpaceEx(pathPtr, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"io"
+	"net/http"
+)
+
+// ResponseRecorder 记录响应状态码与写入字节数的 ResponseWriter 包装
+type ResponseRecorder struct {
+	http.ResponseWriter
+	status  int   // 响应状态码
+	written int64 // 已写入的响应体字节数
+}
+
+// NewResponseRecorder 包装 ResponseWriter，已经是 ResponseRecorder 时直接返回
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	if rec, ok := w.(*ResponseRecorder); ok {
+		return rec
+	}
+	return &ResponseRecorder{ResponseWriter: w}
+}
+
+// Status 返回响应状态码，未显式写入时与 net/http 一致视为 200
+func (rr *ResponseRecorder) Status() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+	return rr.status
+}
+
+// Written 返回已写入的响应体字节数
+func (rr *ResponseRecorder) Written() int64 {
+	return rr.written
+}
+
+func (rr *ResponseRecorder) WriteHeader(code int) {
+	if rr.status == 0 && code >= 200 {
+		// 1xx 信息响应之后还会有最终响应
+		rr.status = code
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *ResponseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.written += int64(n)
+	return n, err
+}
+
+// ReadFrom 保留底层 ResponseWriter 的 sendfile 等优化
+func (rr *ResponseRecorder) ReadFrom(r io.Reader) (int64, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := rr.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(rr.ResponseWriter, r)
+	}
+	rr.written += n
+	return n, err
+}
+
+// Flush 实现 http.Flusher
+func (rr *ResponseRecorder) Flush() {
+	_ = http.NewResponseController(rr.ResponseWriter).Flush()
+}
+
+// Unwrap 供 http.ResponseController 获取底层 ResponseWriter
+func (rr *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
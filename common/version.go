@@ -1,6 +1,9 @@
 package common
 
-import "fmt"
+import (
+	"fmt"
+	"runtime/debug"
+)
 
 // 语义化的版本号 Semantic Versioning
 const (
@@ -9,7 +12,43 @@ const (
 	versionZ byte = 1
 )
 
+// 构建信息，可通过 -ldflags "-X github.com/junlongzzz/file-download-agent/common.commit=<commit>" 注入
+var (
+	commit    string // 构建时的代码提交
+	buildDate string // 构建时间
+)
+
 // Version 获取 x.y.z 文本格式版本号
 func Version() string {
 	return fmt.Sprintf("%v.%v.%v", versionX, versionY, versionZ)
 }
+
+// Commit 获取构建时的代码提交，未注入时尝试读取 go 内置的 vcs 信息
+func Commit() string {
+	if commit != "" {
+		return commit
+	}
+	return buildSetting("vcs.revision")
+}
+
+// BuildDate 获取构建时间，未注入时使用 vcs 提交时间
+func BuildDate() string {
+	if buildDate != "" {
+		return buildDate
+	}
+	return buildSetting("vcs.time")
+}
+
+// 读取 go 构建时嵌入的构建参数
+func buildSetting(key string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == key {
+			return setting.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,709 @@
+package config
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/junlongzzz/file-download-agent/accesslog"
+	"github.com/junlongzzz/file-download-agent/audit"
+	"github.com/junlongzzz/file-download-agent/common"
+	"github.com/junlongzzz/file-download-agent/handler"
+	"github.com/junlongzzz/file-download-agent/passwd"
+	"github.com/junlongzzz/file-download-agent/storage"
+	"github.com/junlongzzz/file-download-agent/tracing"
+	"github.com/junlongzzz/file-download-agent/webhook"
+	"golang.org/x/net/http/httpguts"
+)
+
+// 敏感配置项输出时的替换文本
+const redacted = "******"
+
+// 主服务内置的路由，其他路由不能与之冲突
+var reservedPaths = []string{"/", "/download", "/healthz", "/readyz", "/version", "/webdav/", "/admin/"}
+
+// Config 程序运行配置
+type Config struct {
+	Host        string `yaml:"host"`          // 监听地址
+	Port        int    `yaml:"port"`          // 监听端口
+	SignKey     string `yaml:"sign_key"`      // 下载签名key
+	Dir         string `yaml:"dir"`           // 文件下载目录
+	LogLevel    string `yaml:"log_level"`     // 日志级别
+	CertFile    string `yaml:"cert_file"`     // HTTPS 证书文件
+	CertKeyFile string `yaml:"cert_key_file"` // HTTPS 证书私钥文件
+
+	ShutdownTimeout    time.Duration `yaml:"shutdown_timeout"`     // 停止时等待进行中下载完成的最长时间
+	ShutdownDrainDelay time.Duration `yaml:"shutdown_drain_delay"` // 停止时健康检查返回 draining 后继续接收新请求的时长，等待负载均衡摘除实例
+
+	WebDav    WebDavConfig    `yaml:"webdav"`     // WebDAV 配置
+	Upstream  UpstreamConfig  `yaml:"upstream"`   // 上游请求配置
+	Health    HealthConfig    `yaml:"health"`     // 健康检查配置
+	Metrics   MetricsConfig   `yaml:"metrics"`    // 监控指标配置
+	AccessLog AccessLogConfig `yaml:"access_log"` // 访问日志配置
+	Tracing   TracingConfig   `yaml:"tracing"`    // 链路追踪配置
+	Webhook   WebhookConfig   `yaml:"webhook"`    // 下载事件推送配置
+	Admin     AdminConfig     `yaml:"admin"`      // 管理接口配置
+	Audit     AuditConfig     `yaml:"audit"`      // 审计日志配置
+	Storage   StorageConfig   `yaml:"storage"`    // 文件存储配置
+}
+
+// WebDavConfig WebDAV 服务配置
+type WebDavConfig struct {
+	Enable bool   `yaml:"enable"` // 是否启用
+	Dir    string `yaml:"dir"`    // 根目录，默认同下载目录
+	User   string `yaml:"user"`   // basic 认证用户名
+	Pass   string `yaml:"pass"`   // basic 认证密码，默认同签名key，支持 bcrypt、argon2id 等哈希
+
+	ReadOnly bool   `yaml:"readonly"`  // 全局只读，所有用户都不能修改文件
+	LockFile string `yaml:"lock_file"` // 保存锁的数据库文件，默认为程序目录下的 data/webdav-locks.db
+
+	Quota     int64 `yaml:"quota"`      // 根目录最多使用的字节数，0 表示不限制
+	UserQuota int64 `yaml:"user_quota"` // 未单独设置配额的用户目录最多使用的字节数，0 表示不限制
+
+	Trash          bool          `yaml:"trash"`           // 删除与覆盖的文件移入用户根目录下的 .trash
+	TrashRetention time.Duration `yaml:"trash_retention"` // 回收站保留时长，0 表示不清理
+
+	Versions      int           `yaml:"versions"`        // 覆盖文件时保留的历史版本数，0 表示不限制数量
+	VersionMaxAge time.Duration `yaml:"version_max_age"` // 历史版本保留时长，0 表示不限制，与 versions 都为 0 时不保留版本
+
+	Share bool `yaml:"share"` // 允许用户为文件创建 /download 分享链接，链接保存在 admin.links_file
+
+	Users        []WebDavUserConfig `yaml:"users"`          // 多用户配置，设置后忽略 user 与 pass
+	UsersFile    string             `yaml:"users_file"`     // 多用户配置文件，与 users 合并
+	HtpasswdFile string             `yaml:"htpasswd_file"`  // htpasswd 文件，其中的用户使用与用户名同名的目录
+	AuthCacheTTL time.Duration      `yaml:"auth_cache_ttl"` // 认证成功的缓存时长，0 表示不缓存
+}
+
+// WebDavUserConfig WebDAV 用户配置
+type WebDavUserConfig struct {
+	Name     string `yaml:"name"`     // 用户名
+	Pass     string `yaml:"pass"`     // 密码，明文或哈希
+	Dir      string `yaml:"dir"`      // 根目录，相对路径时位于 WebDAV 目录下，默认为与用户名同名的目录
+	ReadOnly bool   `yaml:"readonly"` // 是否只读
+	Methods  List   `yaml:"methods"`  // 允许使用的方法，为空时不限制
+	Quota    int64  `yaml:"quota"`    // 用户目录最多使用的字节数，默认为 webdav.user_quota
+}
+
+// WebDAV 用户可以限制的方法
+var webDavMethods = []string{
+	"GET", "HEAD", "PROPFIND", "PUT", "DELETE", "MKCOL", "MOVE", "COPY", "PROPPATCH", "LOCK", "UNLOCK",
+}
+
+// UpstreamConfig 上游请求配置
+type UpstreamConfig struct {
+	ProxyRules ProxyRules `yaml:"proxy_rules"` // 按主机名选择代理的规则
+
+	DialTimeout         time.Duration `yaml:"dial_timeout"`            // 建立连接超时
+	TLSTimeout          time.Duration `yaml:"tls_timeout"`             // TLS握手超时
+	HeaderTimeout       time.Duration `yaml:"header_timeout"`          // 等待响应头超时
+	IdleTimeout         time.Duration `yaml:"idle_timeout"`            // 空闲连接保持时间
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"` // 每个主机最大空闲连接数
+	CAFile              string        `yaml:"ca_file"`                 // 额外信任的CA证书
+	InsecureHosts       List          `yaml:"insecure_hosts"`          // 跳过证书校验的主机
+	CertFile            string        `yaml:"cert_file"`               // mTLS 客户端证书
+	KeyFile             string        `yaml:"key_file"`                // mTLS 客户端证书私钥
+	HTTP2               bool          `yaml:"http2"`                   // 是否启用HTTP/2
+
+	MaxRedirects   int  `yaml:"max_redirects"`   // 最大重定向跟随次数
+	PassRedirects  bool `yaml:"pass_redirects"`  // 透传3xx响应而不跟随
+	AllowDowngrade bool `yaml:"allow_downgrade"` // 允许 https 重定向到 http
+
+	ErrorMode      string `yaml:"error_mode"`       // 上游错误响应方式
+	ErrorBodyLimit int64  `yaml:"error_body_limit"` // 透传错误响应体的最大字节数
+	ErrorTemplate  string `yaml:"error_template"`   // 错误页面模板文件
+
+	ForwardRequestHeaders  List `yaml:"forward_request_headers"`  // 允许透传给上游的请求头
+	ForwardResponseHeaders List `yaml:"forward_response_headers"` // 允许透传给客户端的响应头
+}
+
+// HealthConfig 就绪检查配置
+type HealthConfig struct {
+	MinFreeSpace    int64         `yaml:"min_free_space"`   // 目录所在磁盘最少可用空间，单位：字节，0 表示不检查
+	UpstreamUrl     string        `yaml:"upstream_url"`     // 可选的上游探测地址
+	UpstreamTimeout time.Duration `yaml:"upstream_timeout"` // 上游探测超时
+}
+
+// MetricsConfig Prometheus 监控指标配置
+type MetricsConfig struct {
+	Enable bool   `yaml:"enable"` // 是否启用
+	Path   string `yaml:"path"`   // 指标路径
+	Listen string `yaml:"listen"` // 独立监听地址，为空时与主服务共用
+	Token  string `yaml:"token"`  // Bearer 认证 token，为空时不认证，未设置 Listen 时必填
+}
+
+// AccessLogConfig 访问日志配置
+type AccessLogConfig struct {
+	Enable     bool          `yaml:"enable"`      // 是否启用
+	Format     string        `yaml:"format"`      // 输出格式：json, logfmt, combined
+	File       string        `yaml:"file"`        // 日志文件，为空时输出到标准输出
+	MaxSize    int64         `yaml:"max_size"`    // 单个日志文件最大字节数，0 表示不按大小轮转
+	MaxAge     time.Duration `yaml:"max_age"`     // 单个日志文件最长写入时间，0 表示不按时间轮转
+	MaxBackups int           `yaml:"max_backups"` // 保留的历史日志文件数，0 表示全部保留
+}
+
+// TracingConfig OpenTelemetry 链路追踪配置
+type TracingConfig struct {
+	Enable      bool    `yaml:"enable"`       // 是否启用
+	Endpoint    string  `yaml:"endpoint"`     // OTLP/HTTP 接收地址
+	ServiceName string  `yaml:"service_name"` // 服务名称
+	SampleRatio float64 `yaml:"sample_ratio"` // 采样比例
+}
+
+// WebhookConfig 下载事件 webhook 推送配置
+type WebhookConfig struct {
+	Urls       List          `yaml:"urls"`        // 接收事件的地址
+	Secret     string        `yaml:"secret"`      // HMAC 签名密钥
+	Events     List          `yaml:"events"`      // 需要推送的事件，为空时推送全部
+	QueueSize  int           `yaml:"queue_size"`  // 队列长度，队列满时丢弃新事件
+	MaxRetries int           `yaml:"max_retries"` // 推送失败后的最大重试次数
+	Timeout    time.Duration `yaml:"timeout"`     // 单次推送超时
+}
+
+// AdminConfig 管理接口配置
+type AdminConfig struct {
+	Token     string `yaml:"token"`      // Bearer 认证 token，为空时不启用管理接口
+	LinksFile string `yaml:"links_file"` // 管理接口创建的链接保存文件，默认为程序目录下的 data/links.json
+}
+
+// StorageConfig 文件存储配置，非本地存储时下载与 WebDAV 共用存储的根目录，忽略 dir 与 webdav.dir
+type StorageConfig struct {
+	Type string   `yaml:"type"` // local、s3 或 memory
+	S3   S3Config `yaml:"s3"`   // S3 兼容存储配置
+}
+
+// S3Config S3 兼容存储配置
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`   // 服务地址，如 https://s3.us-east-1.amazonaws.com
+	Region    string `yaml:"region"`     // 区域
+	Bucket    string `yaml:"bucket"`     // 存储桶
+	Prefix    string `yaml:"prefix"`     // 对象键前缀
+	AccessKey string `yaml:"access_key"` // 访问密钥 ID，为空时匿名访问
+	SecretKey string `yaml:"secret_key"` // 访问密钥
+	PathStyle bool   `yaml:"path_style"` // 使用 endpoint/bucket 形式的地址
+}
+
+// AuditConfig 审计日志配置
+type AuditConfig struct {
+	Enable      bool   `yaml:"enable"`       // 是否启用
+	Dir         string `yaml:"dir"`          // 存储目录，默认为程序目录下的 audit
+	SegmentSize int64  `yaml:"segment_size"` // 单个分段文件最大字节数
+}
+
+// ProxyRuleConfig 单条代理规则配置
+type ProxyRuleConfig struct {
+	Host  string `yaml:"host"`  // 主机名匹配模式
+	Proxy string `yaml:"proxy"` // direct 或代理地址
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	clientOpts := handler.DefaultClientOptions()
+	return &Config{
+		Port:            18080,
+		LogLevel:        "info",
+		ShutdownTimeout: 30 * time.Second,
+		WebDav: WebDavConfig{
+			Enable:         true,
+			TrashRetention: 30 * 24 * time.Hour,
+			AuthCacheTTL:   time.Minute,
+		},
+		Upstream: UpstreamConfig{
+			DialTimeout:         clientOpts.DialTimeout,
+			TLSTimeout:          clientOpts.TLSHandshakeTimeout,
+			HeaderTimeout:       clientOpts.ResponseHeaderTimeout,
+			IdleTimeout:         clientOpts.IdleConnTimeout,
+			MaxIdleConnsPerHost: clientOpts.MaxIdleConnsPerHost,
+			HTTP2:               true,
+			MaxRedirects:        clientOpts.MaxRedirects,
+			ErrorMode:           string(handler.UpstreamErrorPlain),
+			ErrorBodyLimit:      64 * 1024,
+
+			ForwardRequestHeaders:  handler.DefaultForwardRequestHeaders(),
+			ForwardResponseHeaders: handler.DefaultForwardResponseHeaders(),
+		},
+		Health: HealthConfig{
+			MinFreeSpace:    16 * 1024 * 1024,
+			UpstreamTimeout: 5 * time.Second,
+		},
+		Metrics: MetricsConfig{
+			Path: "/metrics",
+		},
+		AccessLog: AccessLogConfig{
+			Enable:     true,
+			Format:     string(accesslog.FormatJSON),
+			MaxSize:    100 * 1024 * 1024,
+			MaxBackups: 7,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "fda",
+			SampleRatio: 1,
+		},
+		Webhook: WebhookConfig{
+			QueueSize:  webhook.DefaultQueueSize,
+			MaxRetries: 5,
+			Timeout:    10 * time.Second,
+		},
+		Audit: AuditConfig{
+			SegmentSize: audit.DefaultSegmentSize,
+		},
+		Storage: StorageConfig{
+			Type: storage.TypeLocal,
+			S3: S3Config{
+				Region: "us-east-1",
+			},
+		},
+	}
+}
+
+// Validate 校验配置是否合法，返回所有不合法项组成的错误
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(key string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Port <= 0 || c.Port > 65535 {
+		invalid("port", "must be between 1 and 65535, got %d", c.Port)
+	}
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "error":
+	default:
+		invalid("log_level", "must be one of debug, info, warn, error, got %q", c.LogLevel)
+	}
+	if (c.CertFile == "") != (c.CertKeyFile == "") {
+		invalid("cert_file", "cert_file and cert_key_file must be set together")
+	}
+	if c.ShutdownTimeout <= 0 {
+		invalid("shutdown_timeout", "must be a positive duration, got %s", c.ShutdownTimeout)
+	}
+	if c.ShutdownDrainDelay < 0 {
+		invalid("shutdown_drain_delay", "must not be negative, got %s", c.ShutdownDrainDelay)
+	}
+
+	if err := passwd.Validate(c.WebDav.Pass); err != nil {
+		invalid("webdav.pass", "%v", err)
+	}
+	errs = append(errs, validateWebDavUsers("webdav.users", c.WebDav.Users, make(map[string]bool))...)
+	if c.WebDav.Quota < 0 {
+		invalid("webdav.quota", "must not be negative, got %d", c.WebDav.Quota)
+	}
+	if c.WebDav.UserQuota < 0 {
+		invalid("webdav.user_quota", "must not be negative, got %d", c.WebDav.UserQuota)
+	}
+	if c.WebDav.TrashRetention < 0 {
+		invalid("webdav.trash_retention", "must not be negative, got %s", c.WebDav.TrashRetention)
+	}
+	if c.WebDav.Versions < 0 {
+		invalid("webdav.versions", "must not be negative, got %d", c.WebDav.Versions)
+	}
+	if c.WebDav.VersionMaxAge < 0 {
+		invalid("webdav.version_max_age", "must not be negative, got %s", c.WebDav.VersionMaxAge)
+	}
+	if c.WebDav.AuthCacheTTL < 0 {
+		invalid("webdav.auth_cache_ttl", "must not be negative, got %s", c.WebDav.AuthCacheTTL)
+	}
+
+	u := &c.Upstream
+	if _, err := u.ProxyRules.Parse(); err != nil {
+		invalid("upstream.proxy_rules", "%v", err)
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"upstream.dial_timeout", u.DialTimeout},
+		{"upstream.tls_timeout", u.TLSTimeout},
+		{"upstream.header_timeout", u.HeaderTimeout},
+		{"upstream.idle_timeout", u.IdleTimeout},
+	} {
+		if d.value <= 0 {
+			invalid(d.key, "must be a positive duration, got %s", d.value)
+		}
+	}
+	if u.MaxIdleConnsPerHost <= 0 {
+		invalid("upstream.max_idle_conns_per_host", "must be positive, got %d", u.MaxIdleConnsPerHost)
+	}
+	for _, host := range u.InsecureHosts {
+		if err := handler.ValidateHostPattern(host); err != nil {
+			invalid("upstream.insecure_hosts", "%v", err)
+		}
+	}
+	if (u.CertFile == "") != (u.KeyFile == "") {
+		invalid("upstream.cert_file", "upstream cert_file and key_file must be set together")
+	}
+	if u.MaxRedirects <= 0 {
+		invalid("upstream.max_redirects", "must be positive, got %d", u.MaxRedirects)
+	}
+	if _, err := handler.ParseUpstreamErrorMode(u.ErrorMode); err != nil {
+		invalid("upstream.error_mode", "must be one of plain, passthrough, html, json, got %q", u.ErrorMode)
+	}
+	if u.ErrorBodyLimit <= 0 {
+		invalid("upstream.error_body_limit", "must be positive, got %d", u.ErrorBodyLimit)
+	}
+
+	for _, h := range []struct {
+		key     string
+		headers List
+	}{
+		{"upstream.forward_request_headers", u.ForwardRequestHeaders},
+		{"upstream.forward_response_headers", u.ForwardResponseHeaders},
+	} {
+		for _, header := range h.headers {
+			if !httpguts.ValidHeaderFieldName(header) {
+				invalid(h.key, "invalid header name %q", header)
+			}
+		}
+	}
+
+	h := &c.Health
+	if h.MinFreeSpace < 0 {
+		invalid("health.min_free_space", "must not be negative, got %d", h.MinFreeSpace)
+	}
+	if h.UpstreamUrl != "" {
+		if probeUrl, err := url.Parse(h.UpstreamUrl); err != nil || (probeUrl.Scheme != "http" && probeUrl.Scheme != "https") || probeUrl.Host == "" {
+			invalid("health.upstream_url", "must be an absolute http(s) url, got %q", common.RedactURL(h.UpstreamUrl))
+		}
+	}
+	if h.UpstreamTimeout <= 0 {
+		invalid("health.upstream_timeout", "must be a positive duration, got %s", h.UpstreamTimeout)
+	}
+
+	m := &c.Metrics
+	if !strings.HasPrefix(m.Path, "/") {
+		invalid("metrics.path", "must start with /, got %q", m.Path)
+	} else if m.Listen == "" && slices.Contains(reservedPaths, m.Path) {
+		invalid("metrics.path", "conflicts with built-in route %s", m.Path)
+	}
+	if m.Listen != "" {
+		if _, _, err := net.SplitHostPort(m.Listen); err != nil {
+			invalid("metrics.listen", "must be host:port, got %q", m.Listen)
+		}
+	}
+	// 与主服务共用端口时必须认证，避免公开暴露指标
+	if m.Enable && m.Listen == "" && m.Token == "" {
+		invalid("metrics.token", "is required when metrics are served on the main port, set metrics.listen or metrics.token")
+	}
+
+	a := &c.AccessLog
+	if _, err := accesslog.ParseFormat(a.Format); err != nil {
+		invalid("access_log.format", "must be one of json, logfmt, combined, got %q", a.Format)
+	}
+	if a.MaxSize < 0 {
+		invalid("access_log.max_size", "must not be negative, got %d", a.MaxSize)
+	}
+	if a.MaxAge < 0 {
+		invalid("access_log.max_age", "must not be negative, got %s", a.MaxAge)
+	}
+	if a.MaxBackups < 0 {
+		invalid("access_log.max_backups", "must not be negative, got %d", a.MaxBackups)
+	}
+
+	t := &c.Tracing
+	if t.Enable {
+		if endpoint, err := url.Parse(t.Endpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			invalid("tracing.endpoint", "must be an absolute http(s) url, got %q", common.RedactURL(t.Endpoint))
+		}
+		if t.ServiceName == "" {
+			invalid("tracing.service_name", "must not be empty")
+		}
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		invalid("tracing.sample_ratio", "must be between 0 and 1, got %g", t.SampleRatio)
+	}
+
+	wh := &c.Webhook
+	for _, u := range wh.Urls {
+		if hookUrl, err := url.Parse(u); err != nil || (hookUrl.Scheme != "http" && hookUrl.Scheme != "https") || hookUrl.Host == "" {
+			invalid("webhook.urls", "must be an absolute http(s) url, got %q", common.RedactURL(u))
+		}
+	}
+	if len(wh.Urls) > 0 && wh.Secret == "" {
+		invalid("webhook.secret", "must be set when webhook urls are configured")
+	}
+	for _, event := range wh.Events {
+		if _, err := webhook.ParseEventType(event); err != nil {
+			invalid("webhook.events", "%v", err)
+		}
+	}
+	if wh.QueueSize <= 0 {
+		invalid("webhook.queue_size", "must be positive, got %d", wh.QueueSize)
+	}
+	if wh.MaxRetries < 0 {
+		invalid("webhook.max_retries", "must not be negative, got %d", wh.MaxRetries)
+	}
+	if wh.Timeout <= 0 {
+		invalid("webhook.timeout", "must be a positive duration, got %s", wh.Timeout)
+	}
+
+	if c.Audit.SegmentSize <= 0 {
+		invalid("audit.segment_size", "must be positive, got %d", c.Audit.SegmentSize)
+	}
+
+	st := &c.Storage
+	switch st.Type {
+	case storage.TypeLocal, storage.TypeMemory:
+	case storage.TypeS3:
+		if endpoint, err := url.Parse(st.S3.Endpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			invalid("storage.s3.endpoint", "must be an absolute http(s) url, got %q", common.RedactURL(st.S3.Endpoint))
+		}
+		if st.S3.Bucket == "" {
+			invalid("storage.s3.bucket", "must not be empty")
+		}
+		if (st.S3.AccessKey == "") != (st.S3.SecretKey == "") {
+			invalid("storage.s3.access_key", "access_key and secret_key must be set together")
+		}
+	default:
+		invalid("storage.type", "must be one of local, s3, memory, got %q", st.Type)
+	}
+
+	return errors.Join(errs...)
+}
+
+// ClientOptions 转换为上游请求客户端配置
+func (c *Config) ClientOptions() (handler.ClientOptions, error) {
+	rules, err := c.Upstream.ProxyRules.Parse()
+	if err != nil {
+		return handler.ClientOptions{}, err
+	}
+	u := c.Upstream
+	return handler.ClientOptions{
+		ProxyRules:             rules,
+		DialTimeout:            u.DialTimeout,
+		TLSHandshakeTimeout:    u.TLSTimeout,
+		ResponseHeaderTimeout:  u.HeaderTimeout,
+		IdleConnTimeout:        u.IdleTimeout,
+		MaxIdleConnsPerHost:    u.MaxIdleConnsPerHost,
+		CAFile:                 u.CAFile,
+		InsecureHosts:          u.InsecureHosts,
+		ClientCertFile:         u.CertFile,
+		ClientKeyFile:          u.KeyFile,
+		DisableHTTP2:           !u.HTTP2,
+		MaxRedirects:           u.MaxRedirects,
+		PassRedirects:          u.PassRedirects,
+		AllowRedirectDowngrade: u.AllowDowngrade,
+	}, nil
+}
+
+// DownloadOptions 转换为下载处理的可热更新配置
+func (c *Config) DownloadOptions() (handler.DownloadOptions, error) {
+	clientOpts, err := c.ClientOptions()
+	if err != nil {
+		return handler.DownloadOptions{}, err
+	}
+	// 配置已校验，错误响应方式一定合法
+	errorMode, _ := handler.ParseUpstreamErrorMode(c.Upstream.ErrorMode)
+	return handler.DownloadOptions{
+		SignKey:                c.SignKey,
+		Client:                 clientOpts,
+		ForwardRequestHeaders:  c.Upstream.ForwardRequestHeaders,
+		ForwardResponseHeaders: c.Upstream.ForwardResponseHeaders,
+		UpstreamErrorMode:      errorMode,
+		UpstreamErrorBodyLimit: c.Upstream.ErrorBodyLimit,
+		UpstreamErrorTemplate:  c.Upstream.ErrorTemplate,
+	}, nil
+}
+
+// WebDavUsers 合并 users、users_file 与 htpasswd_file 中的用户，转换为 WebDAV 用户
+// 每次调用重新读取用户文件，用户文件不合法或用户名重复时返回错误
+func (c *Config) WebDavUsers() ([]handler.WebDavUser, error) {
+	users := slices.Clone(c.WebDav.Users)
+	seen := make(map[string]bool, len(users))
+	for _, user := range users {
+		seen[user.Name] = true
+	}
+	if c.WebDav.UsersFile != "" {
+		fileUsers, err := loadWebDavUsers(c.WebDav.UsersFile)
+		if err != nil {
+			return nil, err
+		}
+		if err = errors.Join(validateWebDavUsers(c.WebDav.UsersFile, fileUsers, seen)...); err != nil {
+			return nil, err
+		}
+		users = append(users, fileUsers...)
+	}
+	if c.WebDav.HtpasswdFile != "" {
+		entries, err := passwd.LoadHtpasswd(c.WebDav.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		fileUsers := make([]WebDavUserConfig, 0, len(entries))
+		for _, entry := range entries {
+			fileUsers = append(fileUsers, WebDavUserConfig{Name: entry.User, Pass: entry.Hash})
+		}
+		if err = errors.Join(validateWebDavUsers(c.WebDav.HtpasswdFile, fileUsers, seen)...); err != nil {
+			return nil, err
+		}
+		users = append(users, fileUsers...)
+	}
+	result := make([]handler.WebDavUser, 0, len(users))
+	for _, user := range users {
+		dir := user.Dir
+		if dir == "" {
+			dir = user.Name
+		}
+		if !filepath.IsAbs(dir) && !filepath.IsLocal(dir) {
+			return nil, fmt.Errorf("webdav user %s: dir must stay inside the webdav dir, got %q", user.Name, dir)
+		}
+		result = append(result, handler.WebDavUser{
+			Username: user.Name,
+			Password: user.Pass,
+			Dir:      dir,
+			ReadOnly: user.ReadOnly,
+			Methods:  user.Methods,
+			Quota:    cmp.Or(user.Quota, c.WebDav.UserQuota),
+		})
+	}
+	return result, nil
+}
+
+// 校验 WebDAV 用户，用户名不能与 seen 中的及彼此重复
+func validateWebDavUsers(key string, users []WebDavUserConfig, seen map[string]bool) []error {
+	var errs []error
+	for i, user := range users {
+		invalid := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("%s[%d]: %s", key, i, fmt.Sprintf(format, args...)))
+		}
+		switch {
+		case user.Name == "" || strings.Contains(user.Name, ":"):
+			invalid("name must not be empty or contain ':'")
+		case strings.ContainsAny(user.Name, `/\`) || strings.Contains(user.Name, ".."):
+			// 未设置 dir 时用户名即为目录名
+			invalid("name must not contain '/', '\\' or '..', got %q", user.Name)
+		case seen[user.Name]:
+			invalid("duplicate user %q", user.Name)
+		}
+		seen[user.Name] = true
+		if user.Pass == "" {
+			invalid("pass must not be empty")
+		} else if err := passwd.Validate(user.Pass); err != nil {
+			invalid("pass: %v", err)
+		}
+		if user.Dir != "" && !filepath.IsAbs(user.Dir) && !filepath.IsLocal(user.Dir) {
+			invalid("relative dir must stay inside the webdav dir, got %q", user.Dir)
+		}
+		if user.Quota < 0 {
+			invalid("quota must not be negative, got %d", user.Quota)
+		}
+		for _, method := range user.Methods {
+			if !slices.Contains(webDavMethods, strings.ToUpper(method)) {
+				invalid("methods: must be one of %s, got %q", strings.Join(webDavMethods, ", "), method)
+			}
+		}
+	}
+	return errs
+}
+
+// AccessLogOptions 转换为访问日志配置
+func (c *Config) AccessLogOptions() accesslog.Options {
+	// 配置已校验，格式一定合法
+	format, _ := accesslog.ParseFormat(c.AccessLog.Format)
+	return accesslog.Options{
+		Enable:     c.AccessLog.Enable,
+		Format:     format,
+		File:       c.AccessLog.File,
+		MaxSize:    c.AccessLog.MaxSize,
+		MaxAge:     c.AccessLog.MaxAge,
+		MaxBackups: c.AccessLog.MaxBackups,
+	}
+}
+
+// StorageOptions 转换为文件存储配置，dir 为本地存储的根目录
+func (c *Config) StorageOptions(dir string) storage.Options {
+	s3 := c.Storage.S3
+	return storage.Options{
+		Type: c.Storage.Type,
+		Dir:  dir,
+		S3: storage.S3Options{
+			Endpoint:  s3.Endpoint,
+			Region:    s3.Region,
+			Bucket:    s3.Bucket,
+			Prefix:    s3.Prefix,
+			AccessKey: s3.AccessKey,
+			SecretKey: s3.SecretKey,
+			PathStyle: s3.PathStyle,
+		},
+	}
+}
+
+// TracingOptions 转换为链路追踪配置
+func (c *Config) TracingOptions() tracing.Options {
+	return tracing.Options{
+		Endpoint:    c.Tracing.Endpoint,
+		ServiceName: c.Tracing.ServiceName,
+		SampleRatio: c.Tracing.SampleRatio,
+	}
+}
+
+// WebhookOptions 转换为 webhook 推送配置
+func (c *Config) WebhookOptions() webhook.Options {
+	events := make([]webhook.EventType, 0, len(c.Webhook.Events))
+	for _, event := range c.Webhook.Events {
+		events = append(events, webhook.EventType(event))
+	}
+	return webhook.Options{
+		Urls:       c.Webhook.Urls,
+		Secret:     c.Webhook.Secret,
+		Events:     events,
+		MaxRetries: c.Webhook.MaxRetries,
+		Timeout:    c.Webhook.Timeout,
+	}
+}
+
+// Redacted 返回隐藏了敏感信息的配置副本，用于输出展示
+func (c *Config) Redacted() *Config {
+	cp := *c
+	if cp.SignKey != "" {
+		cp.SignKey = redacted
+	}
+	if cp.WebDav.Pass != "" {
+		cp.WebDav.Pass = redacted
+	}
+	cp.WebDav.Users = make([]WebDavUserConfig, len(c.WebDav.Users))
+	for i, user := range c.WebDav.Users {
+		user.Pass = redacted
+		cp.WebDav.Users[i] = user
+	}
+	if cp.Metrics.Token != "" {
+		cp.Metrics.Token = redacted
+	}
+	if cp.Webhook.Secret != "" {
+		cp.Webhook.Secret = redacted
+	}
+	if cp.Admin.Token != "" {
+		cp.Admin.Token = redacted
+	}
+	if cp.Storage.S3.SecretKey != "" {
+		cp.Storage.S3.SecretKey = redacted
+	}
+	// 地址中的凭证与查询参数可能包含令牌
+	cp.Health.UpstreamUrl = redactURL(c.Health.UpstreamUrl)
+	cp.Tracing.Endpoint = redactURL(c.Tracing.Endpoint)
+	cp.Storage.S3.Endpoint = redactURL(c.Storage.S3.Endpoint)
+	cp.Webhook.Urls = make(List, len(c.Webhook.Urls))
+	for i, u := range c.Webhook.Urls {
+		cp.Webhook.Urls[i] = redactURL(u)
+	}
+	cp.Upstream.ProxyRules = make(ProxyRules, len(c.Upstream.ProxyRules))
+	for i, rule := range c.Upstream.ProxyRules {
+		// direct 不是地址，保持不变
+		if proxyUrl, err := url.Parse(rule.Proxy); err == nil && (proxyUrl.User != nil || proxyUrl.RawQuery != "") {
+			rule.Proxy = common.RedactURL(rule.Proxy)
+		}
+		cp.Upstream.ProxyRules[i] = rule
+	}
+	return &cp
+}
+
+// 隐藏地址中的凭证与查询参数，未设置时保持为空
+func redactURL(rawUrl string) string {
+	if rawUrl == "" {
+		return ""
+	}
+	return common.RedactURL(rawUrl)
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// 配置文件 < 环境变量 < 命令行参数
+func TestLoaderLayering(t *testing.T) {
+	file := writeConfig(t, `
+host: 127.0.0.1
+port: 1000
+log_level: warn
+upstream:
+  max_redirects: 3
+`)
+	t.Setenv("FDA_PORT", "2000")
+	t.Setenv("FDA_LOG_LEVEL", "error")
+
+	l := NewLoader("fda")
+	cfg, err := l.Load([]string{"-config", file, "-port", "3000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Host != "127.0.0.1" || cfg.Upstream.MaxRedirects != 3 {
+		t.Errorf("file values = %q, %d, want 127.0.0.1, 3", cfg.Host, cfg.Upstream.MaxRedirects)
+	}
+	if cfg.LogLevel != "error" {
+		t.Errorf("log_level = %q, want env value error", cfg.LogLevel)
+	}
+	if cfg.Port != 3000 {
+		t.Errorf("port = %d, want flag value 3000", cfg.Port)
+	}
+	// 未设置的配置使用默认值
+	if cfg.ShutdownTimeout != 30*time.Second {
+		t.Errorf("shutdown_timeout = %s, want default 30s", cfg.ShutdownTimeout)
+	}
+
+	// 重新加载时读取新的配置文件与环境变量，命令行参数仍然优先
+	if err = os.WriteFile(file, []byte("host: 0.0.0.0\nport: 1000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FDA_LOG_LEVEL", "")
+	if cfg, err = l.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Host != "0.0.0.0" || cfg.LogLevel != "info" || cfg.Port != 3000 || cfg.Upstream.MaxRedirects != Default().Upstream.MaxRedirects {
+		t.Errorf("reloaded = host %q, log_level %q, port %d, max_redirects %d, want 0.0.0.0, info, 3000, default",
+			cfg.Host, cfg.LogLevel, cfg.Port, cfg.Upstream.MaxRedirects)
+	}
+}
+
+func TestLoaderErrors(t *testing.T) {
+	tests := []struct {
+		name, config, env, want string
+	}{
+		{"unknown field", "prot: 1\n", "", "field prot not found"},
+		{"unknown nested field", "webdav:\n  enabel: true\n", "", "field enabel not found"},
+		{"invalid env", "", "abc", "env FDA_PORT=\"abc\": must be an integer"},
+		{"invalid value", "port: 70000\n", "", "port: must be between 1 and 65535"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("FDA_PORT", tt.env)
+			_, err := NewLoader("fda").Load([]string{"-config", writeConfig(t, tt.config)})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+
+	user := func(mutate func(u *WebDavUserConfig)) func(c *Config) {
+		return func(c *Config) {
+			u := WebDavUserConfig{Name: "alice", Pass: "secret"}
+			mutate(&u)
+			c.WebDav.Users = []WebDavUserConfig{u}
+		}
+	}
+	tests := []struct {
+		key    string
+		mutate func(c *Config)
+	}{
+		{"port", func(c *Config) { c.Port = 0 }},
+		{"log_level", func(c *Config) { c.LogLevel = "trace" }},
+		{"cert_file", func(c *Config) { c.CertFile = "cert.pem" }},
+		{"shutdown_timeout", func(c *Config) { c.ShutdownTimeout = 0 }},
+		{"shutdown_drain_delay", func(c *Config) { c.ShutdownDrainDelay = -time.Second }},
+		{"webdav.pass", func(c *Config) { c.WebDav.Pass = "{SHA}invalid" }},
+		{"webdav.users[0]: name", user(func(u *WebDavUserConfig) { u.Name = "" })},
+		{"webdav.users[0]: name", user(func(u *WebDavUserConfig) { u.Name = "../alice" })},
+		{"webdav.users[1]: duplicate", func(c *Config) {
+			c.WebDav.Users = []WebDavUserConfig{{Name: "alice", Pass: "a"}, {Name: "alice", Pass: "b"}}
+		}},
+		{"webdav.users[0]: pass must not be empty", user(func(u *WebDavUserConfig) { u.Pass = "" })},
+		{"webdav.users[0]: pass", user(func(u *WebDavUserConfig) { u.Pass = "$2a$bad" })},
+		{"webdav.users[0]: relative dir", user(func(u *WebDavUserConfig) { u.Dir = "../other" })},
+		{"webdav.users[0]: quota", user(func(u *WebDavUserConfig) { u.Quota = -1 })},
+		{"webdav.users[0]: methods", user(func(u *WebDavUserConfig) { u.Methods = []string{"POST"} })},
+		{"webdav.quota", func(c *Config) { c.WebDav.Quota = -1 }},
+		{"webdav.user_quota", func(c *Config) { c.WebDav.UserQuota = -1 }},
+		{"webdav.trash_retention", func(c *Config) { c.WebDav.TrashRetention = -time.Hour }},
+		{"webdav.versions", func(c *Config) { c.WebDav.Versions = -1 }},
+		{"webdav.version_max_age", func(c *Config) { c.WebDav.VersionMaxAge = -time.Hour }},
+		{"webdav.auth_cache_ttl", func(c *Config) { c.WebDav.AuthCacheTTL = -time.Second }},
+		{"upstream.proxy_rules", func(c *Config) {
+			c.Upstream.ProxyRules = ProxyRules{{Host: "*", Proxy: "ftp://proxy:21"}}
+		}},
+		{"upstream.dial_timeout", func(c *Config) { c.Upstream.DialTimeout = 0 }},
+		{"upstream.tls_timeout", func(c *Config) { c.Upstream.TLSTimeout = 0 }},
+		{"upstream.header_timeout", func(c *Config) { c.Upstream.HeaderTimeout = 0 }},
+		{"upstream.idle_timeout", func(c *Config) { c.Upstream.IdleTimeout = 0 }},
+		{"upstream.max_idle_conns_per_host", func(c *Config) { c.Upstream.MaxIdleConnsPerHost = 0 }},
+		{"upstream.insecure_hosts", func(c *Config) { c.Upstream.InsecureHosts = List{"[a"} }},
+		{"upstream.cert_file", func(c *Config) { c.Upstream.KeyFile = "key.pem" }},
+		{"upstream.max_redirects", func(c *Config) { c.Upstream.MaxRedirects = 0 }},
+		{"upstream.error_mode", func(c *Config) { c.Upstream.ErrorMode = "xml" }},
+		{"upstream.error_body_limit", func(c *Config) { c.Upstream.ErrorBodyLimit = 0 }},
+		{"upstream.forward_request_headers", func(c *Config) { c.Upstream.ForwardRequestHeaders = List{"Bad Header"} }},
+		{"upstream.forward_response_headers", func(c *Config) { c.Upstream.ForwardResponseHeaders = List{"Bad:Header"} }},
+		{"health.min_free_space", func(c *Config) { c.Health.MinFreeSpace = -1 }},
+		{"health.upstream_url", func(c *Config) { c.Health.UpstreamUrl = "example.com/health" }},
+		{"health.upstream_timeout", func(c *Config) { c.Health.UpstreamTimeout = 0 }},
+		{"metrics.path", func(c *Config) { c.Metrics.Path = "metrics" }},
+		{"metrics.path", func(c *Config) { c.Metrics.Path = "/download" }},
+		{"metrics.listen", func(c *Config) { c.Metrics.Listen = "9090" }},
+		{"metrics.token", func(c *Config) { c.Metrics.Enable = true }},
+		{"access_log.format", func(c *Config) { c.AccessLog.Format = "xml" }},
+		{"access_log.max_size", func(c *Config) { c.AccessLog.MaxSize = -1 }},
+		{"access_log.max_age", func(c *Config) { c.AccessLog.MaxAge = -time.Hour }},
+		{"access_log.max_backups", func(c *Config) { c.AccessLog.MaxBackups = -1 }},
+		{"tracing.endpoint", func(c *Config) { c.Tracing.Enable = true }},
+		{"tracing.service_name", func(c *Config) {
+			c.Tracing.Enable, c.Tracing.Endpoint, c.Tracing.ServiceName = true, "http://localhost:4318", ""
+		}},
+		{"tracing.sample_ratio", func(c *Config) { c.Tracing.SampleRatio = 2 }},
+		{"webhook.urls", func(c *Config) { c.Webhook.Urls, c.Webhook.Secret = List{"ftp://hook"}, "s" }},
+		{"webhook.secret", func(c *Config) { c.Webhook.Urls = List{"https://hook.example.com"} }},
+		{"webhook.events", func(c *Config) { c.Webhook.Events = List{"download.paused"} }},
+		{"webhook.queue_size", func(c *Config) { c.Webhook.QueueSize = 0 }},
+		{"webhook.max_retries", func(c *Config) { c.Webhook.MaxRetries = -1 }},
+		{"webhook.timeout", func(c *Config) { c.Webhook.Timeout = 0 }},
+		{"audit.segment_size", func(c *Config) { c.Audit.SegmentSize = 0 }},
+		{"storage.type", func(c *Config) { c.Storage.Type = "ftp" }},
+		{"storage.s3.endpoint", func(c *Config) { c.Storage.Type, c.Storage.S3.Bucket = "s3", "files" }},
+		{"storage.s3.bucket", func(c *Config) { c.Storage.Type, c.Storage.S3.Endpoint = "s3", "https://s3.example.com" }},
+		{"storage.s3.access_key", func(c *Config) {
+			c.Storage.Type, c.Storage.S3.Endpoint, c.Storage.S3.Bucket = "s3", "https://s3.example.com", "files"
+			c.Storage.S3.AccessKey = "AKID"
+		}},
+	}
+	for _, tt := range tests {
+		c := Default()
+		tt.mutate(c)
+		err := c.Validate()
+		// 每项只产生一个错误，确认拒绝的是预期的字段
+		if err == nil || strings.Count(err.Error(), "\n") != 0 || !strings.HasPrefix(err.Error(), tt.key) {
+			t.Errorf("%s: Validate error = %v, want a single error for %s", tt.key, err, tt.key)
+		}
+	}
+}
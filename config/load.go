@@ -0,0 +1,385 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/junlongzzz/file-download-agent/handler"
+	"gopkg.in/yaml.v3"
+)
+
+// 命令行参数与环境变量的对应关系
+var flagEnvs = []struct {
+	flag, env string
+}{
+	{"host", "FDA_HOST"},
+	{"port", "FDA_PORT"},
+	{"sign-key", "FDA_SIGN_KEY"},
+	{"dir", "FDA_DIR"},
+	{"log-level", "FDA_LOG_LEVEL"},
+	{"cert-file", "FDA_CERT_FILE"},
+	{"cert-key-file", "FDA_CERT_KEY_FILE"},
+	{"shutdown-timeout", "FDA_SHUTDOWN_TIMEOUT"},
+	{"shutdown-drain-delay", "FDA_SHUTDOWN_DRAIN_DELAY"},
+	{"webdav-enable", "FDA_WEBDAV_ENABLE"},
+	{"webdav-dir", "FDA_WEBDAV_DIR"},
+	{"webdav-user", "FDA_WEBDAV_USER"},
+	{"webdav-pass", "FDA_WEBDAV_PASS"},
+	{"webdav-readonly", "FDA_WEBDAV_READONLY"},
+	{"webdav-lock-file", "FDA_WEBDAV_LOCK_FILE"},
+	{"webdav-quota", "FDA_WEBDAV_QUOTA"},
+	{"webdav-user-quota", "FDA_WEBDAV_USER_QUOTA"},
+	{"webdav-trash", "FDA_WEBDAV_TRASH"},
+	{"webdav-trash-retention", "FDA_WEBDAV_TRASH_RETENTION"},
+	{"webdav-versions", "FDA_WEBDAV_VERSIONS"},
+	{"webdav-version-max-age", "FDA_WEBDAV_VERSION_MAX_AGE"},
+	{"webdav-share", "FDA_WEBDAV_SHARE"},
+	{"webdav-users-file", "FDA_WEBDAV_USERS_FILE"},
+	{"webdav-htpasswd-file", "FDA_WEBDAV_HTPASSWD_FILE"},
+	{"webdav-auth-cache-ttl", "FDA_WEBDAV_AUTH_CACHE_TTL"},
+	{"proxy-rules", "FDA_PROXY_RULES"},
+	{"upstream-dial-timeout", "FDA_UPSTREAM_DIAL_TIMEOUT"},
+	{"upstream-tls-timeout", "FDA_UPSTREAM_TLS_TIMEOUT"},
+	{"upstream-header-timeout", "FDA_UPSTREAM_HEADER_TIMEOUT"},
+	{"upstream-idle-timeout", "FDA_UPSTREAM_IDLE_TIMEOUT"},
+	{"upstream-max-idle-per-host", "FDA_UPSTREAM_MAX_IDLE_PER_HOST"},
+	{"upstream-ca-file", "FDA_UPSTREAM_CA_FILE"},
+	{"upstream-insecure-hosts", "FDA_UPSTREAM_INSECURE_HOSTS"},
+	{"upstream-cert-file", "FDA_UPSTREAM_CERT_FILE"},
+	{"upstream-key-file", "FDA_UPSTREAM_KEY_FILE"},
+	{"upstream-http2", "FDA_UPSTREAM_HTTP2"},
+	{"upstream-max-redirects", "FDA_UPSTREAM_MAX_REDIRECTS"},
+	{"upstream-pass-redirects", "FDA_UPSTREAM_PASS_REDIRECTS"},
+	{"upstream-allow-downgrade", "FDA_UPSTREAM_ALLOW_DOWNGRADE"},
+	{"upstream-error-mode", "FDA_UPSTREAM_ERROR_MODE"},
+	{"upstream-error-body-limit", "FDA_UPSTREAM_ERROR_BODY_LIMIT"},
+	{"upstream-error-template", "FDA_UPSTREAM_ERROR_TEMPLATE"},
+	{"upstream-forward-req-headers", "FDA_UPSTREAM_FORWARD_REQ_HEADERS"},
+	{"upstream-forward-resp-headers", "FDA_UPSTREAM_FORWARD_RESP_HEADERS"},
+	{"health-min-free-space", "FDA_HEALTH_MIN_FREE_SPACE"},
+	{"health-upstream-url", "FDA_HEALTH_UPSTREAM_URL"},
+	{"health-upstream-timeout", "FDA_HEALTH_UPSTREAM_TIMEOUT"},
+	{"metrics-enable", "FDA_METRICS_ENABLE"},
+	{"metrics-path", "FDA_METRICS_PATH"},
+	{"metrics-listen", "FDA_METRICS_LISTEN"},
+	{"metrics-token", "FDA_METRICS_TOKEN"},
+	{"access-log-enable", "FDA_ACCESS_LOG_ENABLE"},
+	{"access-log-format", "FDA_ACCESS_LOG_FORMAT"},
+	{"access-log-file", "FDA_ACCESS_LOG_FILE"},
+	{"access-log-max-size", "FDA_ACCESS_LOG_MAX_SIZE"},
+	{"access-log-max-age", "FDA_ACCESS_LOG_MAX_AGE"},
+	{"access-log-max-backups", "FDA_ACCESS_LOG_MAX_BACKUPS"},
+	{"tracing-enable", "FDA_TRACING_ENABLE"},
+	{"tracing-endpoint", "FDA_TRACING_ENDPOINT"},
+	{"tracing-service-name", "FDA_TRACING_SERVICE_NAME"},
+	{"tracing-sample-ratio", "FDA_TRACING_SAMPLE_RATIO"},
+	{"webhook-urls", "FDA_WEBHOOK_URLS"},
+	{"webhook-secret", "FDA_WEBHOOK_SECRET"},
+	{"webhook-events", "FDA_WEBHOOK_EVENTS"},
+	{"webhook-queue-size", "FDA_WEBHOOK_QUEUE_SIZE"},
+	{"webhook-max-retries", "FDA_WEBHOOK_MAX_RETRIES"},
+	{"webhook-timeout", "FDA_WEBHOOK_TIMEOUT"},
+	{"admin-token", "FDA_ADMIN_TOKEN"},
+	{"admin-links-file", "FDA_ADMIN_LINKS_FILE"},
+	{"audit-enable", "FDA_AUDIT_ENABLE"},
+	{"audit-dir", "FDA_AUDIT_DIR"},
+	{"audit-segment-size", "FDA_AUDIT_SEGMENT_SIZE"},
+	{"storage-type", "FDA_STORAGE_TYPE"},
+	{"storage-s3-endpoint", "FDA_STORAGE_S3_ENDPOINT"},
+	{"storage-s3-region", "FDA_STORAGE_S3_REGION"},
+	{"storage-s3-bucket", "FDA_STORAGE_S3_BUCKET"},
+	{"storage-s3-prefix", "FDA_STORAGE_S3_PREFIX"},
+	{"storage-s3-access-key", "FDA_STORAGE_S3_ACCESS_KEY"},
+	{"storage-s3-secret-key", "FDA_STORAGE_S3_SECRET_KEY"},
+	{"storage-s3-path-style", "FDA_STORAGE_S3_PATH_STYLE"},
+}
+
+// Loader 配置加载器
+// 按 配置文件 < 环境变量 < 命令行参数 的优先级合并配置
+type Loader struct {
+	FlagSet *flag.FlagSet // 绑定到配置的命令行参数
+
+	cfg   *Config           // 命令行参数绑定的配置
+	file  string            // 配置文件路径
+	flags map[string]string // 显式传入的命令行参数
+}
+
+// NewLoader 创建配置加载器并注册所有命令行参数
+func NewLoader(name string) *Loader {
+	l := &Loader{
+		FlagSet: flag.NewFlagSet(name, flag.ExitOnError),
+		cfg:     Default(),
+	}
+	fs, cfg := l.FlagSet, l.cfg
+	fs.StringVar(&l.file, "config", os.Getenv("FDA_CONFIG"), "config file path (yaml)")
+	fs.StringVar(&cfg.Host, "host", cfg.Host, "server host (default 0.0.0.0)")
+	fs.IntVar(&cfg.Port, "port", cfg.Port, "server port")
+	fs.StringVar(&cfg.SignKey, "sign-key", cfg.SignKey, "server download sign key")
+	fs.StringVar(&cfg.Dir, "dir", cfg.Dir, "download directory (default ./files)")
+	fs.BoolVar(&cfg.WebDav.Enable, "webdav-enable", cfg.WebDav.Enable, "enable webdav server or not")
+	fs.StringVar(&cfg.WebDav.Dir, "webdav-dir", cfg.WebDav.Dir, "webdav root directory (default <dir>)")
+	fs.StringVar(&cfg.WebDav.User, "webdav-user", cfg.WebDav.User, "webdav username (default anonymous)")
+	fs.StringVar(&cfg.WebDav.Pass, "webdav-pass", cfg.WebDav.Pass, "webdav password or bcrypt/argon2id hash (default <sign_key>)")
+	fs.BoolVar(&cfg.WebDav.ReadOnly, "webdav-readonly", cfg.WebDav.ReadOnly, "serve webdav read-only for all users")
+	fs.StringVar(&cfg.WebDav.LockFile, "webdav-lock-file", cfg.WebDav.LockFile, "database file persisting webdav locks (default ./data/webdav-locks.db)")
+	fs.Int64Var(&cfg.WebDav.Quota, "webdav-quota", cfg.WebDav.Quota, "max bytes stored in the webdav dir, 0 to disable")
+	fs.Int64Var(&cfg.WebDav.UserQuota, "webdav-user-quota", cfg.WebDav.UserQuota, "max bytes stored by each webdav user without own quota, 0 to disable")
+	fs.BoolVar(&cfg.WebDav.Trash, "webdav-trash", cfg.WebDav.Trash, "move deleted and overwritten webdav files to the user's .trash")
+	fs.DurationVar(&cfg.WebDav.TrashRetention, "webdav-trash-retention", cfg.WebDav.TrashRetention, "how long trashed webdav files are kept, 0 to keep forever")
+	fs.IntVar(&cfg.WebDav.Versions, "webdav-versions", cfg.WebDav.Versions, "number of previous versions kept when a webdav file is overwritten")
+	fs.DurationVar(&cfg.WebDav.VersionMaxAge, "webdav-version-max-age", cfg.WebDav.VersionMaxAge, "how long previous versions of webdav files are kept, 0 for no limit")
+	fs.BoolVar(&cfg.WebDav.Share, "webdav-share", cfg.WebDav.Share, "allow webdav users to create download links for files")
+	fs.StringVar(&cfg.WebDav.UsersFile, "webdav-users-file", cfg.WebDav.UsersFile, "yaml file of webdav users with their own dirs and permissions")
+	fs.StringVar(&cfg.WebDav.HtpasswdFile, "webdav-htpasswd-file", cfg.WebDav.HtpasswdFile, "htpasswd file of webdav users, each user gets a home dir")
+	fs.DurationVar(&cfg.WebDav.AuthCacheTTL, "webdav-auth-cache-ttl", cfg.WebDav.AuthCacheTTL, "how long a successful webdav login is cached, 0 to disable")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: debug, info, warn, error")
+	fs.StringVar(&cfg.CertFile, "cert-file", cfg.CertFile, "cert file path")
+	fs.StringVar(&cfg.CertKeyFile, "cert-key-file", cfg.CertKeyFile, "cert key file path")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "max time to wait for in-flight downloads on shutdown")
+	fs.DurationVar(&cfg.ShutdownDrainDelay, "shutdown-drain-delay", cfg.ShutdownDrainDelay, "how long to keep serving new requests after health checks report draining on shutdown")
+
+	u := &cfg.Upstream
+	fs.Var(&u.ProxyRules, "proxy-rules", "upstream proxy rules: <host_pattern>=<direct|http://...|socks5://...>, comma separated")
+	fs.DurationVar(&u.DialTimeout, "upstream-dial-timeout", u.DialTimeout, "upstream connect timeout")
+	fs.DurationVar(&u.TLSTimeout, "upstream-tls-timeout", u.TLSTimeout, "upstream tls handshake timeout")
+	fs.DurationVar(&u.HeaderTimeout, "upstream-header-timeout", u.HeaderTimeout, "upstream response header timeout")
+	fs.DurationVar(&u.IdleTimeout, "upstream-idle-timeout", u.IdleTimeout, "upstream idle connection timeout")
+	fs.IntVar(&u.MaxIdleConnsPerHost, "upstream-max-idle-per-host", u.MaxIdleConnsPerHost, "upstream max idle connections per host")
+	fs.StringVar(&u.CAFile, "upstream-ca-file", u.CAFile, "extra ca bundle (pem) trusted for upstream tls")
+	fs.Var(&u.InsecureHosts, "upstream-insecure-hosts", "upstream host patterns skipping tls verification, comma separated")
+	fs.StringVar(&u.CertFile, "upstream-cert-file", u.CertFile, "client cert file for upstream mtls")
+	fs.StringVar(&u.KeyFile, "upstream-key-file", u.KeyFile, "client cert key file for upstream mtls")
+	fs.BoolVar(&u.HTTP2, "upstream-http2", u.HTTP2, "enable http/2 for upstream requests")
+	fs.IntVar(&u.MaxRedirects, "upstream-max-redirects", u.MaxRedirects, "upstream max redirects to follow")
+	fs.BoolVar(&u.PassRedirects, "upstream-pass-redirects", u.PassRedirects, "pass upstream 3xx responses to client instead of following")
+	fs.BoolVar(&u.AllowDowngrade, "upstream-allow-downgrade", u.AllowDowngrade, "allow upstream redirects from https to http")
+	fs.StringVar(&u.ErrorMode, "upstream-error-mode", u.ErrorMode, "response for upstream errors: plain, passthrough, html, json")
+	fs.Int64Var(&u.ErrorBodyLimit, "upstream-error-body-limit", u.ErrorBodyLimit, "max bytes of upstream error body in passthrough mode")
+	fs.StringVar(&u.ErrorTemplate, "upstream-error-template", u.ErrorTemplate, "html template file for upstream error page")
+	fs.Var(&u.ForwardRequestHeaders, "upstream-forward-req-headers", "request headers forwarded to upstream, comma separated")
+	fs.Var(&u.ForwardResponseHeaders, "upstream-forward-resp-headers", "upstream response headers forwarded to client, comma separated")
+
+	h := &cfg.Health
+	fs.Int64Var(&h.MinFreeSpace, "health-min-free-space", h.MinFreeSpace, "readyz min free disk space in bytes, 0 to disable")
+	fs.StringVar(&h.UpstreamUrl, "health-upstream-url", h.UpstreamUrl, "readyz optional upstream url to probe")
+	fs.DurationVar(&h.UpstreamTimeout, "health-upstream-timeout", h.UpstreamTimeout, "readyz upstream probe timeout")
+
+	m := &cfg.Metrics
+	fs.BoolVar(&m.Enable, "metrics-enable", m.Enable, "enable prometheus metrics endpoint")
+	fs.StringVar(&m.Path, "metrics-path", m.Path, "metrics endpoint path")
+	fs.StringVar(&m.Listen, "metrics-listen", m.Listen, "separate listen address for metrics, e.g. 127.0.0.1:9090 (default same as server)")
+	fs.StringVar(&m.Token, "metrics-token", m.Token, "bearer token required to scrape metrics")
+
+	a := &cfg.AccessLog
+	fs.BoolVar(&a.Enable, "access-log-enable", a.Enable, "enable access log")
+	fs.StringVar(&a.Format, "access-log-format", a.Format, "access log format: json, logfmt, combined")
+	fs.StringVar(&a.File, "access-log-file", a.File, "access log file (default stdout)")
+	fs.Int64Var(&a.MaxSize, "access-log-max-size", a.MaxSize, "rotate access log file after max bytes, 0 to disable")
+	fs.DurationVar(&a.MaxAge, "access-log-max-age", a.MaxAge, "rotate access log file after duration, 0 to disable")
+	fs.IntVar(&a.MaxBackups, "access-log-max-backups", a.MaxBackups, "number of rotated access log files to keep, 0 to keep all")
+
+	t := &cfg.Tracing
+	fs.BoolVar(&t.Enable, "tracing-enable", t.Enable, "enable opentelemetry tracing")
+	fs.StringVar(&t.Endpoint, "tracing-endpoint", t.Endpoint, "otlp/http endpoint url, e.g. http://localhost:4318")
+	fs.StringVar(&t.ServiceName, "tracing-service-name", t.ServiceName, "service name reported in traces")
+	fs.Float64Var(&t.SampleRatio, "tracing-sample-ratio", t.SampleRatio, "ratio of requests to trace, 0 to 1")
+
+	wh := &cfg.Webhook
+	fs.Var(&wh.Urls, "webhook-urls", "webhook urls receiving download events, comma separated")
+	fs.StringVar(&wh.Secret, "webhook-secret", wh.Secret, "hmac-sha256 secret for signing webhook events")
+	fs.Var(&wh.Events, "webhook-events", "webhook events to send, comma separated (default all)")
+	fs.IntVar(&wh.QueueSize, "webhook-queue-size", wh.QueueSize, "max queued webhook events, newer events are dropped when full")
+	fs.IntVar(&wh.MaxRetries, "webhook-max-retries", wh.MaxRetries, "max retries of a failed webhook delivery")
+	fs.DurationVar(&wh.Timeout, "webhook-timeout", wh.Timeout, "webhook delivery timeout")
+
+	fs.StringVar(&cfg.Admin.Token, "admin-token", cfg.Admin.Token, "bearer token for /admin endpoints, admin endpoints are disabled when empty")
+	fs.StringVar(&cfg.Admin.LinksFile, "admin-links-file", cfg.Admin.LinksFile, "file storing links created by the admin API (default ./data/links.json)")
+	fs.BoolVar(&cfg.Audit.Enable, "audit-enable", cfg.Audit.Enable, "record downloads and webdav writes to the audit log")
+	fs.StringVar(&cfg.Audit.Dir, "audit-dir", cfg.Audit.Dir, "audit log directory (default ./audit)")
+	fs.Int64Var(&cfg.Audit.SegmentSize, "audit-segment-size", cfg.Audit.SegmentSize, "max bytes of an audit log segment file")
+
+	st := &cfg.Storage
+	fs.StringVar(&st.Type, "storage-type", st.Type, "file storage for downloads and webdav: local, s3, memory")
+	fs.StringVar(&st.S3.Endpoint, "storage-s3-endpoint", st.S3.Endpoint, "s3 compatible endpoint url")
+	fs.StringVar(&st.S3.Region, "storage-s3-region", st.S3.Region, "s3 region")
+	fs.StringVar(&st.S3.Bucket, "storage-s3-bucket", st.S3.Bucket, "s3 bucket")
+	fs.StringVar(&st.S3.Prefix, "storage-s3-prefix", st.S3.Prefix, "s3 key prefix files are stored under")
+	fs.StringVar(&st.S3.AccessKey, "storage-s3-access-key", st.S3.AccessKey, "s3 access key id, anonymous requests when empty")
+	fs.StringVar(&st.S3.SecretKey, "storage-s3-secret-key", st.S3.SecretKey, "s3 secret access key")
+	fs.BoolVar(&st.S3.PathStyle, "storage-s3-path-style", st.S3.PathStyle, "use path-style s3 urls (endpoint/bucket), usually needed for minio")
+	return l
+}
+
+// File 返回使用的配置文件路径，未使用时为空
+func (l *Loader) File() string {
+	return l.file
+}
+
+// Load 解析命令行参数并加载配置
+func (l *Loader) Load(args []string) (*Config, error) {
+	if err := l.FlagSet.Parse(args); err != nil {
+		return nil, err
+	}
+	// 记录显式传入的命令行参数，在合并配置文件与环境变量后重新应用
+	l.flags = make(map[string]string)
+	l.FlagSet.Visit(func(f *flag.Flag) {
+		l.flags[f.Name] = f.Value.String()
+	})
+	return l.Reload()
+}
+
+// Reload 重新读取配置文件与环境变量，并应用启动时传入的命令行参数
+// 每次返回新的配置对象，校验失败时返回错误
+func (l *Loader) Reload() (*Config, error) {
+	*l.cfg = *Default()
+
+	if l.file != "" {
+		if err := l.loadFile(); err != nil {
+			return nil, err
+		}
+	}
+	for _, fe := range flagEnvs {
+		if value := os.Getenv(fe.env); value != "" {
+			if err := l.FlagSet.Set(fe.flag, value); err != nil {
+				return nil, fmt.Errorf("env %s=%q: %v", fe.env, value, l.flagError(fe.flag, err))
+			}
+		}
+	}
+	for name, value := range l.flags {
+		if name == "config" {
+			continue
+		}
+		if err := l.FlagSet.Set(name, value); err != nil {
+			return nil, fmt.Errorf("flag -%s=%q: %v", name, value, l.flagError(name, err))
+		}
+	}
+
+	if err := l.cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config:\n%v", err)
+	}
+	cfg := *l.cfg
+	return &cfg, nil
+}
+
+// 读取 yaml 配置文件，未知字段视为错误
+func (l *Loader) loadFile() error {
+	content, err := os.ReadFile(l.file)
+	if err != nil {
+		return fmt.Errorf("read config file error: %v", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(l.cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %v", l.file, err)
+	}
+	return nil
+}
+
+// 读取 WebDAV 用户文件，格式同配置文件中的 webdav.users
+func loadWebDavUsers(file string) ([]WebDavUserConfig, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read webdav users file error: %v", err)
+	}
+	var users struct {
+		Users []WebDavUserConfig `yaml:"users"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&users); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("webdav users file %s: %v", file, err)
+	}
+	return users.Users, nil
+}
+
+// flag 内置类型的解析错误只有 parse error，按参数类型给出更易读的提示
+func (l *Loader) flagError(name string, err error) error {
+	f := l.FlagSet.Lookup(name)
+	if f == nil {
+		return err
+	}
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return err
+	}
+	switch getter.Get().(type) {
+	case bool:
+		return errors.New("must be a boolean (true or false)")
+	case int, int64:
+		return errors.New("must be an integer")
+	case float64:
+		return errors.New("must be a number")
+	case time.Duration:
+		return errors.New("must be a duration, e.g. 30s, 5m")
+	default:
+		return err
+	}
+}
+
+// Marshal 将配置序列化为 yaml
+func Marshal(cfg *Config) ([]byte, error) {
+	return yaml.Marshal(cfg)
+}
+
+// List 逗号分隔的字符串列表，可用作命令行参数
+type List []string
+
+func (l *List) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *List) Set(s string) error {
+	var list List
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*l = list
+	return nil
+}
+
+// ProxyRules 代理规则列表，命令行参数格式: <pattern>=<target>[,<pattern>=<target>...]
+type ProxyRules []ProxyRuleConfig
+
+func (pr *ProxyRules) String() string {
+	var items []string
+	for _, rule := range *pr {
+		items = append(items, rule.Host+"="+rule.Proxy)
+	}
+	return strings.Join(items, ",")
+}
+
+func (pr *ProxyRules) Set(s string) error {
+	rules, err := handler.ParseProxyRules(s)
+	if err != nil {
+		return err
+	}
+	list := make(ProxyRules, 0, len(rules))
+	for _, item := range strings.Split(s, ",") {
+		if pattern, target, ok := strings.Cut(strings.TrimSpace(item), "="); ok {
+			list = append(list, ProxyRuleConfig{Host: strings.TrimSpace(pattern), Proxy: strings.TrimSpace(target)})
+		}
+	}
+	*pr = list
+	return nil
+}
+
+// Parse 转换为 handler 使用的代理规则
+func (pr ProxyRules) Parse() ([]handler.ProxyRule, error) {
+	rules := make([]handler.ProxyRule, 0, len(pr))
+	for _, rule := range pr {
+		r, err := handler.NewProxyRule(rule.Host, rule.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
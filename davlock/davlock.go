@@ -0,0 +1,321 @@
+package davlock
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/net/webdav"
+)
+
+// 保存锁的 bucket
+var locksBucket = []byte("locks")
+
+// Lock 一个 WebDAV 锁
+type Lock struct {
+	Token     string        `json:"token"`
+	Root      string        `json:"root"`
+	Duration  time.Duration `json:"duration"` // 负数表示永不过期
+	OwnerXML  string        `json:"owner_xml,omitempty"`
+	ZeroDepth bool          `json:"zero_depth"`
+	CreatedAt time.Time     `json:"created_at"`
+	ExpiresAt time.Time     `json:"expires_at,omitzero"` // 永不过期时为零值
+
+	held bool // 正在被请求使用，使用期间不过期，不保存
+}
+
+// 是否保存到数据库，永不过期的锁只保存在内存中
+// webdav.Handler 为没有 If 头的写请求创建的临时锁永不过期，进程异常退出时未释放的临时锁不能在重启后保留
+func (l *Lock) persistent() bool {
+	return l.Duration >= 0
+}
+
+func (l *Lock) expired(now time.Time) bool {
+	return !l.held && !l.ExpiresAt.IsZero() && !now.Before(l.ExpiresAt)
+}
+
+// 是否锁定了 name，无限深度的锁同时锁定所有子路径
+func (l *Lock) covers(name string) bool {
+	if name == l.Root {
+		return true
+	}
+	return !l.ZeroDepth && (l.Root == "/" || strings.HasPrefix(name, l.Root+"/"))
+}
+
+// LockSystem 保存在本地 bbolt 数据库中的 webdav.LockSystem，重启后锁仍然有效
+// 所有锁同时保存在内存中，修改时同步写入数据库
+type LockSystem struct {
+	db *bolt.DB
+
+	mu    sync.Mutex
+	locks map[string]*Lock // token -> lock
+}
+
+// Open 打开锁数据库，加载未过期的锁，旧版本保存的永不过期的锁被丢弃
+func Open(file string) (*LockSystem, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return nil, fmt.Errorf("create lock directory: %w", err)
+	}
+	db, err := bolt.Open(file, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open lock database: %w", err)
+	}
+	ls := &LockSystem{db: db, locks: make(map[string]*Lock)}
+	now := time.Now()
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(locksBucket)
+		if err != nil {
+			return err
+		}
+		var expired [][]byte
+		err = bucket.ForEach(func(k, v []byte) error {
+			var lock Lock
+			if json.Unmarshal(v, &lock) != nil || lock.expired(now) || !lock.persistent() {
+				expired = append(expired, k)
+				return nil
+			}
+			ls.locks[lock.Token] = &lock
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err = bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("load locks: %w", err)
+	}
+	return ls, nil
+}
+
+// Close 关闭数据库
+func (ls *LockSystem) Close() error {
+	if ls == nil {
+		return nil
+	}
+	return ls.db.Close()
+}
+
+// List 返回所有未过期的锁，按创建时间排序
+func (ls *LockSystem) List() []Lock {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(time.Now())
+	list := make([]Lock, 0, len(ls.locks))
+	for _, lock := range ls.locks {
+		list = append(list, *lock)
+	}
+	slices.SortFunc(list, func(a, b Lock) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return list
+}
+
+// Remove 强制解除锁，不检查是否正在使用
+func (ls *LockSystem) Remove(token string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if _, ok := ls.locks[token]; !ok {
+		return webdav.ErrNoSuchLock
+	}
+	return ls.delete(token)
+}
+
+func (ls *LockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	var l0, l1 *Lock
+	if name0 != "" {
+		if l0 = ls.lookup(clean(name0), conditions...); l0 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	if name1 != "" {
+		if l1 = ls.lookup(clean(name1), conditions...); l1 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	if l1 == l0 {
+		l1 = nil
+	}
+	for _, l := range []*Lock{l0, l1} {
+		if l != nil {
+			l.held = true
+		}
+	}
+	return func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		for _, l := range []*Lock{l0, l1} {
+			if l != nil {
+				l.held = false
+			}
+		}
+	}, nil
+}
+
+// 查找锁定 name 且符合条件、未被占用的锁
+func (ls *LockSystem) lookup(name string, conditions ...webdav.Condition) *Lock {
+	for _, c := range conditions {
+		if l := ls.locks[c.Token]; l != nil && !l.held && l.covers(name) {
+			return l
+		}
+	}
+	return nil
+}
+
+func (ls *LockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	root := clean(details.Root)
+	for _, l := range ls.locks {
+		// 已被锁定，或无限深度的锁包含已锁定的子路径
+		if l.covers(root) || (!details.ZeroDepth && (root == "/" || strings.HasPrefix(l.Root, root+"/"))) {
+			return "", webdav.ErrLocked
+		}
+	}
+	lock := &Lock{
+		Token:     newToken(),
+		Root:      root,
+		Duration:  details.Duration,
+		OwnerXML:  details.OwnerXML,
+		ZeroDepth: details.ZeroDepth,
+		CreatedAt: now,
+	}
+	if lock.Duration >= 0 {
+		lock.ExpiresAt = now.Add(lock.Duration)
+	}
+	if err := ls.put(lock); err != nil {
+		return "", err
+	}
+	ls.locks[lock.Token] = lock
+	return lock.Token, nil
+}
+
+func (ls *LockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	lock := ls.locks[token]
+	if lock == nil {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	if lock.held {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+	refreshed := *lock
+	refreshed.Duration, refreshed.ExpiresAt = duration, time.Time{}
+	if duration >= 0 {
+		refreshed.ExpiresAt = now.Add(duration)
+	}
+	if err := ls.put(&refreshed); err != nil {
+		return webdav.LockDetails{}, err
+	}
+	*lock = refreshed
+	return lock.details(), nil
+}
+
+// Root 返回锁定的路径，不修改锁
+func (ls *LockSystem) Root(now time.Time, token string) (string, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+	lock := ls.locks[token]
+	if lock == nil {
+		return "", webdav.ErrNoSuchLock
+	}
+	return lock.Root, nil
+}
+
+func (ls *LockSystem) Unlock(now time.Time, token string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	lock := ls.locks[token]
+	if lock == nil {
+		return webdav.ErrNoSuchLock
+	}
+	if lock.held {
+		return webdav.ErrLocked
+	}
+	return ls.delete(token)
+}
+
+func (l *Lock) details() webdav.LockDetails {
+	return webdav.LockDetails{Root: l.Root, Duration: l.Duration, OwnerXML: l.OwnerXML, ZeroDepth: l.ZeroDepth}
+}
+
+// 删除过期的锁，调用方需持有锁
+func (ls *LockSystem) collectExpired(now time.Time) {
+	for token, lock := range ls.locks {
+		if lock.expired(now) {
+			_ = ls.delete(token)
+		}
+	}
+}
+
+// 保存锁到数据库，调用方需持有锁
+// 永不过期的锁不保存，刷新为永不过期时从数据库中删除
+func (ls *LockSystem) put(lock *Lock) error {
+	if !lock.persistent() {
+		if old := ls.locks[lock.Token]; old == nil || !old.persistent() {
+			return nil
+		}
+		return ls.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(locksBucket).Delete([]byte(lock.Token))
+		})
+	}
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	return ls.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(locksBucket).Put([]byte(lock.Token), data)
+	})
+}
+
+// 从内存与数据库中删除锁，调用方需持有锁
+func (ls *LockSystem) delete(token string) error {
+	lock := ls.locks[token]
+	delete(ls.locks, token)
+	if lock != nil && !lock.persistent() {
+		return nil
+	}
+	return ls.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(locksBucket).Delete([]byte(token))
+	})
+}
+
+// 统一为以 / 开头且不以 / 结尾的路径
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+// 随机生成 urn:uuid 格式的锁 token，重启后不会重复
+func newToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,95 @@
+package davlock
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func open(t *testing.T, file string) *LockSystem {
+	t.Helper()
+	ls, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ls
+}
+
+// 进程在写请求释放临时锁之前退出，重启后文件不能一直处于锁定状态
+func TestUnreleasedTemporaryLockIsNotPersisted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "locks.db")
+	now := time.Now()
+	ls := open(t, file)
+
+	// 与 webdav.Handler 处理没有 If 头的 PUT 相同：创建永不过期的锁并确认，未释放
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/a.txt", Duration: -1, ZeroDepth: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ls.Confirm(now, "/a.txt", "", webdav.Condition{Token: token}); err != nil {
+		t.Fatal(err)
+	}
+	// 客户端 LOCK 请求的锁需要保留
+	kept, err := ls.Create(now, webdav.LockDetails{Root: "/b.txt", Duration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ls.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ls = open(t, file)
+	defer ls.Close()
+	if _, err = ls.Create(now, webdav.LockDetails{Root: "/a.txt", Duration: -1, ZeroDepth: true}); err != nil {
+		t.Fatalf("lock /a.txt after restart: %v, want unlocked", err)
+	}
+	locks := ls.List()
+	if len(locks) != 2 || locks[0].Token != kept {
+		t.Fatalf("locks after restart = %+v, want %s and the new temporary lock", locks, kept)
+	}
+	if _, err = ls.Create(now, webdav.LockDetails{Root: "/b.txt", Duration: time.Hour}); err != webdav.ErrLocked {
+		t.Fatalf("lock /b.txt after restart error = %v, want ErrLocked", err)
+	}
+}
+
+func TestRefreshToInfiniteIsNotPersisted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "locks.db")
+	now := time.Now()
+	ls := open(t, file)
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/a.txt", Duration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ls.Refresh(now, token, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err = ls.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ls = open(t, file)
+	defer ls.Close()
+	if locks := ls.List(); len(locks) != 0 {
+		t.Fatalf("locks after restart = %+v, want none", locks)
+	}
+}
+
+func TestExpiredLocksAreDroppedOnOpen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "locks.db")
+	past := time.Now().Add(-2 * time.Hour)
+	ls := open(t, file)
+	if _, err := ls.Create(past, webdav.LockDetails{Root: "/a.txt", Duration: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ls = open(t, file)
+	defer ls.Close()
+	if locks := ls.List(); len(locks) != 0 {
+		t.Fatalf("locks after restart = %+v, want none", locks)
+	}
+}
@@ -0,0 +1,296 @@
+package handler
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/junlongzzz/file-download-agent/links"
+)
+
+//go:embed openapi.json
+var adminOpenAPI []byte
+
+// 请求体最大字节数
+const maxAdminBodySize = 64 * 1024
+
+// AdminHandler 管理接口，用于创建、查询、撤销下载链接
+type AdminHandler struct {
+	mux      *http.ServeMux
+	download *DownloadHandler
+	links    *links.Store
+}
+
+// NewAdminHandler 创建Handler，链接使用 download 的签名key加密
+func NewAdminHandler(download *DownloadHandler, store *links.Store) *AdminHandler {
+	ah := &AdminHandler{mux: http.NewServeMux(), download: download, links: store}
+	ah.mux.HandleFunc("GET /admin/api/openapi.json", ah.openAPI)
+	ah.mux.HandleFunc("POST /admin/api/links", ah.createLink)
+	ah.mux.HandleFunc("GET /admin/api/links", ah.listLinks)
+	ah.mux.HandleFunc("GET /admin/api/links/{id}", ah.getLink)
+	ah.mux.HandleFunc("DELETE /admin/api/links/{id}", ah.revokeLink)
+	ah.mux.HandleFunc("POST /admin/api/inspect", ah.inspect)
+	ah.mux.HandleFunc("GET /admin/api/stats", ah.stats)
+	return ah
+}
+
+func (ah *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ah.mux.ServeHTTP(w, r)
+}
+
+// 返回给管理接口的链接，附带当前状态与下载地址
+type adminLink struct {
+	*links.Link
+	Status      string `json:"status"`
+	Enc         string `json:"enc,omitempty"`
+	DownloadUrl string `json:"download_url,omitempty"`
+}
+
+// 创建链接的请求体，expire 与 ttl 只能设置一个
+type createLinkRequest struct {
+	Url      string `json:"url"`
+	Filename string `json:"filename"`
+	Expire   int64  `json:"expire"` // 过期时间戳，单位：秒
+	TTL      string `json:"ttl"`    // 有效时长，如 24h
+	MaxUses  int64  `json:"max_uses"`
+	Note     string `json:"note"`
+}
+
+// 检查链接的请求体，enc 与 url 只能设置一个
+type inspectRequest struct {
+	Enc string `json:"enc"` // enc 参数
+	Url string `json:"url"` // 完整的下载地址，支持 enc 与 sign 两种链接
+}
+
+// 检查链接的结果
+type inspectResult struct {
+	Valid   bool            `json:"valid"`
+	Type    string          `json:"type"` // enc 或 sign
+	Error   string          `json:"error,omitempty"`
+	Params  *DownloadParams `json:"params,omitempty"`
+	Expired bool            `json:"expired"`
+	Link    *adminLink      `json:"link,omitempty"`
+}
+
+func (ah *AdminHandler) openAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(adminOpenAPI)
+}
+
+func (ah *AdminHandler) createLink(w http.ResponseWriter, r *http.Request) {
+	var body createLinkRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodySize)).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.Url == "" {
+		http.Error(w, "Missing required parameter: url", http.StatusBadRequest)
+		return
+	}
+	if u, err := url.Parse(body.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file" && u.Scheme != "webdav") {
+		http.Error(w, "Invalid url", http.StatusBadRequest)
+		return
+	}
+	if body.MaxUses < 0 {
+		http.Error(w, "Invalid max_uses: must not be negative", http.StatusBadRequest)
+		return
+	}
+	if body.TTL != "" {
+		if body.Expire != 0 {
+			http.Error(w, "Only one of expire and ttl can be set", http.StatusBadRequest)
+			return
+		}
+		ttl, err := time.ParseDuration(body.TTL)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "Invalid ttl: must be a positive duration, e.g. 24h", http.StatusBadRequest)
+			return
+		}
+		body.Expire = time.Now().Add(ttl).Unix()
+	}
+	if body.Expire != 0 && body.Expire <= time.Now().Unix() {
+		http.Error(w, "Invalid expire: must be a future UNIX timestamp", http.StatusBadRequest)
+		return
+	}
+
+	link, err := ah.links.Create(links.Link{
+		Url:      body.Url,
+		Filename: body.Filename,
+		Expire:   body.Expire,
+		MaxUses:  body.MaxUses,
+		Note:     body.Note,
+		Creator:  "admin",
+	})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Create link error: %v", err))
+		http.Error(w, "Failed to create link", http.StatusInternalServerError)
+		return
+	}
+	result, err := newAdminLink(r, ah.download, link)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Encrypt link error: %v", err))
+		http.Error(w, "Failed to encrypt data", http.StatusInternalServerError)
+		return
+	}
+	slog.Info(fmt.Sprintf("Link created: %s", link.ID))
+	writeJSON(w, http.StatusCreated, result)
+}
+
+func (ah *AdminHandler) listLinks(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", links.StatusActive, links.StatusRevoked, links.StatusExpired, links.StatusExhausted:
+	default:
+		http.Error(w, fmt.Sprintf("Invalid status: must be %s, %s, %s or %s",
+			links.StatusActive, links.StatusRevoked, links.StatusExpired, links.StatusExhausted), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	list := ah.links.List(status)
+	result := make([]*adminLink, 0, len(list))
+	for _, link := range list {
+		result = append(result, &adminLink{Link: link, Status: link.Status(now)})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"links": result})
+}
+
+func (ah *AdminHandler) getLink(w http.ResponseWriter, r *http.Request) {
+	link, ok := ah.links.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+	result, err := newAdminLink(r, ah.download, link)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Encrypt link error: %v", err))
+		http.Error(w, "Failed to encrypt data", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (ah *AdminHandler) revokeLink(w http.ResponseWriter, r *http.Request) {
+	link, err := ah.links.Revoke(r.PathValue("id"))
+	if errors.Is(err, links.ErrNotFound) {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error(fmt.Sprintf("Revoke link error: %v", err))
+		http.Error(w, "Failed to revoke link", http.StatusInternalServerError)
+		return
+	}
+	slog.Info(fmt.Sprintf("Link revoked: %s", link.ID))
+	writeJSON(w, http.StatusOK, &adminLink{Link: link, Status: link.Status(time.Now())})
+}
+
+// 解析 enc 或签名链接，返回参数与可用状态，不计入使用次数
+func (ah *AdminHandler) inspect(w http.ResponseWriter, r *http.Request) {
+	var body inspectRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodySize)).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if (body.Enc == "") == (body.Url == "") {
+		http.Error(w, "Exactly one of enc and url must be set", http.StatusBadRequest)
+		return
+	}
+	params := &DownloadParams{}
+	if body.Url != "" {
+		u, err := url.Parse(body.Url)
+		if err != nil {
+			http.Error(w, "Failed to parse url", http.StatusBadRequest)
+			return
+		}
+		query := u.Query()
+		body.Enc = query.Get("enc")
+		params.Url = query.Get("url")
+		params.Filename = query.Get("filename")
+		params.Expire = query.Get("expire")
+		params.Sign = query.Get("sign")
+	}
+
+	signKey := ah.download.settings.Load().signKey
+	result := &inspectResult{Valid: true, Type: "sign"}
+	if body.Enc != "" {
+		result.Type = "enc"
+		var err error
+		if params, _, err = decodeEnc(r.Context(), signKey, body.Enc); err != nil {
+			result.Valid, result.Error = false, "Invalid enc"
+			writeJSON(w, http.StatusOK, result)
+			return
+		}
+	} else if params.Url == "" {
+		http.Error(w, "Missing enc or url parameter in url", http.StatusBadRequest)
+		return
+	} else if signKey != "" && !signMatches(signKey, params) {
+		result.Valid, result.Error = false, "Invalid sign"
+	}
+	params.Sign = ""
+	result.Params = params
+
+	if params.Expire != "" {
+		timestamp, err := strconv.ParseInt(params.Expire, 10, 64)
+		if err != nil {
+			result.Valid, result.Error = false, "Invalid expire parameter: must be a valid UNIX timestamp"
+		} else if time.Now().After(time.Unix(timestamp, 0)) {
+			result.Expired = true
+			if result.Valid {
+				result.Valid, result.Error = false, "Link has expired"
+			}
+		}
+	}
+	if params.ID != "" {
+		link, ok := ah.links.Get(params.ID)
+		if ok {
+			result.Link = &adminLink{Link: link, Status: link.Status(time.Now())}
+		}
+		if reqErr := linkError(ah.links.Check(params.ID)); reqErr != nil && result.Valid {
+			result.Valid, result.Error = false, reqErr.msg
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (ah *AdminHandler) stats(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"links":            ah.links.Stats(),
+		"active_downloads": ah.download.Active(),
+	})
+}
+
+// 生成链接的 enc 参数与完整下载地址，地址根据请求的协议与主机名生成
+func newAdminLink(r *http.Request, download *DownloadHandler, link *links.Link) (*adminLink, error) {
+	params := &DownloadParams{ID: link.ID, Url: link.Url, Filename: link.Filename}
+	if link.Expire > 0 {
+		params.Expire = strconv.FormatInt(link.Expire, 10)
+	}
+	enc, err := download.encode(params)
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	} else if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return &adminLink{
+		Link:        link,
+		Status:      link.Status(time.Now()),
+		Enc:         enc,
+		DownloadUrl: fmt.Sprintf("%s://%s/download?enc=%s", scheme, r.Host, enc),
+	}, nil
+}
+
+// 返回JSON格式的响应
+func writeJSON(w http.ResponseWriter, code int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(data)
+}
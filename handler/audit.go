@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/junlongzzz/file-download-agent/audit"
+)
+
+// 审计日志单页最大记录数
+const maxAuditPageSize = 1000
+
+type AuditHandler struct {
+	store *audit.Store
+}
+
+// NewAuditHandler 创建Handler
+func NewAuditHandler(store *audit.Store) *AuditHandler {
+	return &AuditHandler{store: store}
+}
+
+// 审计日志查询处理函数，按时间倒序分页返回
+// 支持参数 kind, link_id, user, ip, target, status, since, until, before, limit
+func (ah *AuditHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, next, err := ah.store.Query(filter)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Query audit log error: %v", err))
+		http.Error(w, "Failed to query audit log", http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []audit.Record{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"records": records,
+		"next":    next,
+	})
+}
+
+// 解析查询参数
+func parseAuditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Kind:     query.Get("kind"),
+		LinkID:   query.Get("link_id"),
+		User:     query.Get("user"),
+		ClientIP: query.Get("ip"),
+		Target:   query.Get("target"),
+		Limit:    100,
+	}
+	if filter.Kind != "" && filter.Kind != audit.KindDownload && filter.Kind != audit.KindWebDav {
+		return filter, fmt.Errorf("Invalid kind: must be %s or %s", audit.KindDownload, audit.KindWebDav)
+	}
+	if s := query.Get("status"); s != "" {
+		status, err := strconv.Atoi(s)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid status: %s", s)
+		}
+		filter.Status = status
+	}
+	for _, t := range []struct {
+		name  string
+		value *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		if s := query.Get(t.name); s != "" {
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid %s: must be RFC 3339 time, e.g. 2006-01-02T15:04:05Z", t.name)
+			}
+			*t.value = parsed
+		}
+	}
+	if s := query.Get("before"); s != "" {
+		before, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid before: %s", s)
+		}
+		filter.Before = before
+	}
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > maxAuditPageSize {
+			return filter, fmt.Errorf("Invalid limit: must be between 1 and %d", maxAuditPageSize)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ClientOptions 上游请求http客户端配置
+type ClientOptions struct {
+	ProxyRules []ProxyRule // 按主机名选择上游代理的规则
+
+	DialTimeout           time.Duration // 建立TCP连接超时
+	TLSHandshakeTimeout   time.Duration // TLS握手超时
+	ResponseHeaderTimeout time.Duration // 等待响应头超时
+	IdleConnTimeout       time.Duration // 空闲连接保持时间
+	MaxIdleConnsPerHost   int           // 每个主机最大空闲连接数
+
+	CAFile         string   // 额外信任的CA证书文件（PEM），追加到系统证书池
+	InsecureHosts  []string // 跳过TLS证书校验的主机名匹配模式
+	ClientCertFile string   // mTLS 客户端证书文件
+	ClientKeyFile  string   // mTLS 客户端证书私钥文件
+	DisableHTTP2   bool     // 禁用HTTP/2，仅使用HTTP/1.1
+
+	MaxRedirects           int  // 最大重定向跟随次数
+	PassRedirects          bool // 不跟随重定向，直接将3xx响应透传给客户端
+	AllowRedirectDowngrade bool // 允许 https 重定向到 http
+}
+
+// DefaultClientOptions 返回默认的上游请求客户端配置
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		DialTimeout:           30 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 60 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   http.DefaultMaxIdleConnsPerHost,
+		MaxRedirects:          20,
+	}
+}
+
+// 默认的请求发起http客户端
+func defaultHTTPClient(opts ClientOptions) (*http.Client, error) {
+	transport, err := newTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: checkRedirect(opts),
+	}, nil
+}
+
+// 根据配置生成重定向校验函数
+func checkRedirect(opts ClientOptions) func(*http.Request, []*http.Request) error {
+	maxRedirects := opts.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = DefaultClientOptions().MaxRedirects
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if opts.PassRedirects {
+			// 不跟随重定向，将3xx响应返回给调用方
+			return http.ErrUseLastResponse
+		}
+		// 设置最大重定向次数
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("too many redirects")
+		}
+		prev := via[len(via)-1]
+		if !opts.AllowRedirectDowngrade && prev.URL.Scheme == "https" && req.URL.Scheme == "http" {
+			return fmt.Errorf("refused redirect downgrade from https to http: %s", req.URL.Redacted())
+		}
+		if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+			// 跨主机重定向时移除凭证类请求头，避免泄露给第三方
+			req.Header.Del("Authorization")
+			req.Header.Del("Cookie")
+		}
+		return nil
+	}
+}
+
+// 根据配置创建上游请求的 Transport
+func newTransport(opts ClientOptions) (*http.Transport, error) {
+	defaults := DefaultClientOptions()
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = defaults.DialTimeout
+	}
+	if opts.TLSHandshakeTimeout <= 0 {
+		opts.TLSHandshakeTimeout = defaults.TLSHandshakeTimeout
+	}
+	if opts.ResponseHeaderTimeout <= 0 {
+		opts.ResponseHeaderTimeout = defaults.ResponseHeaderTimeout
+	}
+	if opts.IdleConnTimeout <= 0 {
+		opts.IdleConnTimeout = defaults.IdleConnTimeout
+	}
+	if opts.MaxIdleConnsPerHost <= 0 {
+		opts.MaxIdleConnsPerHost = defaults.MaxIdleConnsPerHost
+	}
+
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyFunc(opts.ProxyRules)
+	transport.DialContext = (&net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+	transport.IdleConnTimeout = opts.IdleConnTimeout
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	transport.TLSClientConfig = tlsConfig
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(!opts.DisableHTTP2)
+	transport.Protocols = protocols
+	return transport, nil
+}
+
+// 根据配置创建上游请求的 TLS 配置
+func newTLSConfig(opts ClientOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if opts.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file error: %v", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate found in ca file: %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.ClientCertFile != "" || opts.ClientKeyFile != "" {
+		if opts.ClientCertFile == "" || opts.ClientKeyFile == "" {
+			return nil, fmt.Errorf("client cert file and key file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client cert error: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(opts.InsecureHosts) > 0 {
+		for _, pattern := range opts.InsecureHosts {
+			if err := ValidateHostPattern(pattern); err != nil {
+				return nil, fmt.Errorf("invalid insecure host: %v", err)
+			}
+		}
+		insecureHosts := opts.InsecureHosts
+		// tls.Config 无法按主机单独设置 InsecureSkipVerify，
+		// 因此关闭内置校验，改为在 VerifyConnection 内对未命中的主机自行校验证书链
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, pattern := range insecureHosts {
+				if matchHost(pattern, cs.ServerName) {
+					return nil
+				}
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("tls: no peer certificates")
+			}
+			verifyOpts := x509.VerifyOptions{
+				Roots:         tlsConfig.RootCAs,
+				DNSName:       cs.ServerName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				verifyOpts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(verifyOpts)
+			return err
+		}
+	}
+	return tlsConfig, nil
+}
@@ -1,32 +1,130 @@
 package handler
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"path"
-	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/junlongzzz/file-download-agent/accesslog"
+	"github.com/junlongzzz/file-download-agent/audit"
 	"github.com/junlongzzz/file-download-agent/common"
+	"github.com/junlongzzz/file-download-agent/links"
+	"github.com/junlongzzz/file-download-agent/metrics"
+	"github.com/junlongzzz/file-download-agent/storage"
+	"github.com/junlongzzz/file-download-agent/tracing"
+	"github.com/junlongzzz/file-download-agent/versions"
+	"github.com/junlongzzz/file-download-agent/webhook"
+	"golang.org/x/net/webdav"
 )
 
 type DownloadHandler struct {
+	files  storage.Storage // file:// 链接的文件存储
+	active atomic.Int64    // 进行中的下载数
+
+	mu       sync.Mutex                       // 修改配置时加锁，避免并发修改丢失
+	settings atomic.Pointer[downloadSettings] // 可热更新的配置，整体原子替换
+
+	notifier atomic.Pointer[webhook.Notifier] // 下载事件推送
+	auditLog atomic.Pointer[audit.Store]      // 审计日志
+	links    atomic.Pointer[links.Store]      // 管理接口创建的链接
+	davFiles atomic.Pointer[storage.Storage]  // webdav:// 链接的文件存储，即 WebDAV 根目录，为空时不支持
+}
+
+// 可在运行期间热更新的下载配置
+// 每个请求开始时读取一次，进行中的下载不受后续更新影响
+type downloadSettings struct {
 	signKey            string          // 参数校验签名key
 	client             *http.Client    // 发起请求的http客户端
-	dir                string          // 文件下载目录
 	forwardReqHeaders  map[string]bool // 允许透传的请求头白名单
 	forwardRespHeaders map[string]bool // 允许透传的响应头白名单
+
+	upstreamErrorMode      UpstreamErrorMode  // 上游错误响应方式
+	upstreamErrorBodyLimit int64              // 透传上游错误响应体的最大字节数
+	upstreamErrorTemplate  *template.Template // 上游错误页面模板
+}
+
+// DownloadOptions 下载处理的完整可热更新配置
+type DownloadOptions struct {
+	SignKey                string            // 参数校验签名key
+	Client                 ClientOptions     // 上游请求客户端配置
+	ForwardRequestHeaders  []string          // 允许透传的请求头，为空时使用默认白名单
+	ForwardResponseHeaders []string          // 允许透传的响应头，为空时使用默认白名单
+	UpstreamErrorMode      UpstreamErrorMode // 上游错误响应方式
+	UpstreamErrorBodyLimit int64             // 透传上游错误响应体的最大字节数
+	UpstreamErrorTemplate  string            // 上游错误页面模板文件
+}
+
+// 默认允许透传的请求头白名单
+var defaultForwardRequestHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Cache-Control",
+	"Range", // 支持断点续传
+	"If-Range",
+	"If-None-Match",
+	"If-Modified-Since",
+	"User-Agent",
+	"Authorization",
+	"Cookie",
+	"Referer",
+	"Origin",
+}
+
+// 默认允许透传的响应头白名单
+var defaultForwardResponseHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Disposition", // 用于文件名
+	"Content-Range",       // 断点续传支持
+	"Content-Encoding",
+	"Content-Language",
+	"Accept-Ranges",
+	"Last-Modified",
+	"ETag",
+	"Cache-Control",
+	"Expires",
+	"Date",
+	"Set-Cookie",
+}
+
+// DefaultForwardRequestHeaders 返回默认允许透传的请求头
+func DefaultForwardRequestHeaders() []string {
+	return slices.Clone(defaultForwardRequestHeaders)
+}
+
+// DefaultForwardResponseHeaders 返回默认允许透传的响应头
+func DefaultForwardResponseHeaders() []string {
+	return slices.Clone(defaultForwardResponseHeaders)
+}
+
+// 请求头列表转换为白名单，统一为规范格式
+func headerSet(headers []string) map[string]bool {
+	set := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		set[http.CanonicalHeaderKey(strings.TrimSpace(header))] = true
+	}
+	return set
 }
 
 type DownloadParams struct {
+	ID       string `json:"id,omitempty"`       // 服务端保存的链接ID，由管理接口生成
 	Url      string `json:"url"`                // 下载链接
 	Filename string `json:"filename,omitempty"` // 下载保存文件名
 	Expire   string `json:"expire,omitempty"`   // 下载链接有效期 截止时间的时间戳，单位：秒
@@ -34,66 +132,187 @@ type DownloadParams struct {
 }
 
 // NewDownloadHandler 初始化并赋默认值
-func NewDownloadHandler(dir, signKey string) *DownloadHandler {
-	return &DownloadHandler{
-		signKey: signKey,
-		client:  defaultHTTPClient(),
-		dir:     dir,
-		forwardReqHeaders: map[string]bool{
-			"Accept":            true,
-			"Accept-Encoding":   true,
-			"Accept-Language":   true,
-			"Cache-Control":     true,
-			"Range":             true, // 支持断点续传
-			"If-Range":          true,
-			"If-None-Match":     true,
-			"If-Modified-Since": true,
-			"User-Agent":        true,
-			"Authorization":     true,
-			"Cookie":            true,
-			"Referer":           true,
-			"Origin":            true,
-		},
-		forwardRespHeaders: map[string]bool{
-			"Content-Type":        true,
-			"Content-Length":      true,
-			"Content-Disposition": true, // 用于文件名
-			"Content-Range":       true, // 断点续传支持
-			"Content-Encoding":    true,
-			"Content-Language":    true,
-			"Accept-Ranges":       true,
-			"Last-Modified":       true,
-			"ETag":                true,
-			"Cache-Control":       true,
-			"Expires":             true,
-			"Date":                true,
-			"Set-Cookie":          true,
-		},
+func NewDownloadHandler(files storage.Storage, signKey string) *DownloadHandler {
+	// 空配置不会产生错误
+	client, _ := defaultHTTPClient(ClientOptions{})
+	dh := &DownloadHandler{files: files}
+	dh.settings.Store(&downloadSettings{
+		signKey:                signKey,
+		client:                 client,
+		forwardReqHeaders:      headerSet(defaultForwardRequestHeaders),
+		forwardRespHeaders:     headerSet(defaultForwardResponseHeaders),
+		upstreamErrorMode:      UpstreamErrorPlain,
+		upstreamErrorBodyLimit: defaultUpstreamErrorBodyLimit,
+		upstreamErrorTemplate:  template.Must(template.New("upstream_error").Parse(defaultUpstreamErrorTemplate)),
+	})
+	return dh
+}
+
+// Active 返回进行中的下载数
+func (dh *DownloadHandler) Active() int64 {
+	return dh.active.Load()
+}
+
+// Client 返回当前使用的上游请求http客户端
+func (dh *DownloadHandler) Client() *http.Client {
+	return dh.settings.Load().client
+}
+
+// 复制当前配置并修改，修改成功后原子替换
+func (dh *DownloadHandler) update(fn func(s *downloadSettings) error) error {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+	s := *dh.settings.Load()
+	if err := fn(&s); err != nil {
+		return err
+	}
+	old := dh.settings.Swap(&s)
+	if old.client != s.client {
+		// 旧客户端不再接收新请求，释放其空闲连接，进行中的请求不受影响
+		old.client.CloseIdleConnections()
 	}
+	return nil
 }
 
-// 默认的请求发起http客户端
-func defaultHTTPClient() *http.Client {
-	return &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			// 设置最大重定向次数
-			if len(via) >= 20 {
-				return fmt.Errorf("too many redirects")
-			}
-			return nil
-		},
+// Update 使用完整配置替换当前配置，任一项不合法时保持原配置不变
+func (dh *DownloadHandler) Update(opts DownloadOptions) error {
+	apply, err := dh.Prepare(opts)
+	if err != nil {
+		return err
 	}
+	apply()
+	return nil
+}
+
+// Prepare 校验完整配置，返回替换当前配置的函数，任一项不合法时返回错误
+func (dh *DownloadHandler) Prepare(opts DownloadOptions) (func(), error) {
+	client, err := defaultHTTPClient(opts.Client)
+	if err != nil {
+		return nil, err
+	}
+	if opts.UpstreamErrorMode == "" {
+		opts.UpstreamErrorMode = UpstreamErrorPlain
+	}
+	if opts.UpstreamErrorBodyLimit <= 0 {
+		opts.UpstreamErrorBodyLimit = defaultUpstreamErrorBodyLimit
+	}
+	tmpl, err := parseUpstreamErrorTemplate(opts.UpstreamErrorTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.ForwardRequestHeaders) == 0 {
+		opts.ForwardRequestHeaders = defaultForwardRequestHeaders
+	}
+	if len(opts.ForwardResponseHeaders) == 0 {
+		opts.ForwardResponseHeaders = defaultForwardResponseHeaders
+	}
+	settings := downloadSettings{
+		signKey:                opts.SignKey,
+		client:                 client,
+		forwardReqHeaders:      headerSet(opts.ForwardRequestHeaders),
+		forwardRespHeaders:     headerSet(opts.ForwardResponseHeaders),
+		upstreamErrorMode:      opts.UpstreamErrorMode,
+		upstreamErrorBodyLimit: opts.UpstreamErrorBodyLimit,
+		upstreamErrorTemplate:  tmpl,
+	}
+	return func() {
+		_ = dh.update(func(s *downloadSettings) error {
+			*s = settings
+			return nil
+		})
+	}, nil
+}
+
+// SetSignKey 设置参数校验签名key
+func (dh *DownloadHandler) SetSignKey(signKey string) {
+	_ = dh.update(func(s *downloadSettings) error {
+		s.signKey = signKey
+		return nil
+	})
 }
 
 // SetClient 设置HttpClient
 func (dh *DownloadHandler) SetClient(client *http.Client) {
 	if client != nil {
-		dh.client = client
+		_ = dh.update(func(s *downloadSettings) error {
+			s.client = client
+			return nil
+		})
+	}
+}
+
+// SetClientOptions 根据配置重新创建HttpClient
+func (dh *DownloadHandler) SetClientOptions(opts ClientOptions) error {
+	client, err := defaultHTTPClient(opts)
+	if err != nil {
+		return err
+	}
+	return dh.update(func(s *downloadSettings) error {
+		s.client = client
+		return nil
+	})
+}
+
+// SetNotifier 设置下载事件的 webhook 推送
+func (dh *DownloadHandler) SetNotifier(notifier *webhook.Notifier) {
+	dh.notifier.Store(notifier)
+}
+
+// SetAuditLog 设置审计日志
+func (dh *DownloadHandler) SetAuditLog(store *audit.Store) {
+	dh.auditLog.Store(store)
+}
+
+// SetLinkStore 设置管理接口创建的链接存储，为空时带链接ID的下载链接均不可用
+func (dh *DownloadHandler) SetLinkStore(store *links.Store) {
+	dh.links.Store(store)
+}
+
+// SetWebDavStorage 设置 webdav:// 链接的文件存储，即 WebDAV 根目录
+func (dh *DownloadHandler) SetWebDavStorage(files storage.Storage) {
+	dh.davFiles.Store(&files)
+}
+
+// 使用服务端签名key加密下载参数，生成 enc 参数
+func (dh *DownloadHandler) encode(params *DownloadParams) (string, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	encrypt, err := common.Encrypt(dh.settings.Load().signKey, data)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(encrypt), nil
+}
+
+// 推送 webhook 事件，补充链接标识与客户端信息
+func (dh *DownloadHandler) notify(r *http.Request, e webhook.Event) {
+	if e.LinkID == "" {
+		e.LinkID = requestLinkID(r)
 	}
+	e.ClientIP = common.GetRealIP(r)
+	e.UserAgent = r.UserAgent()
+	dh.notifier.Load().Notify(e)
 }
 
 // 文件下载处理函数，实现了 Handler 接口
 func (dh *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 记录实际写出的状态码与字节数，请求结束后写入审计日志
+	rec := common.NewResponseRecorder(w)
+	w = rec
+	record := audit.Record{
+		Kind:      audit.KindDownload,
+		Method:    r.Method,
+		LinkID:    requestLinkID(r),
+		ClientIP:  common.GetRealIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	defer func() {
+		record.Status, record.Bytes = rec.Status(), rec.Written()
+		dh.auditLog.Load().Append(record)
+	}()
+
 	if r.Method == http.MethodPost {
 		// POST请求用于处理数据
 		// 获取请求体参数
@@ -105,6 +324,7 @@ func (dh *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		defer func(Body io.ReadCloser) {
 			_ = Body.Close()
 		}(r.Body)
+		record.Target = common.RedactURL(body.Url)
 
 		if body.Url == "" {
 			_ = dh.jsonResponse(w, http.StatusBadRequest, "Missing required parameter: url", nil)
@@ -131,26 +351,121 @@ func (dh *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 读取当前配置，本次请求全程使用同一份配置
+	settings := dh.settings.Load()
+
+	ctx, span := tracing.Tracer().Start(r.Context(), "verify link")
+	params, parseUrl, reqErr := dh.resolveParams(r.WithContext(ctx), settings)
+	if params.Url != "" {
+		record.Target = common.RedactURL(params.Url)
+	}
+	if params.ID != "" {
+		record.LinkID = params.ID
+	}
+	if reqErr != nil {
+		tracing.Error(span, reqErr)
+		span.End()
+		http.Error(w, reqErr.msg, reqErr.code)
+		return
+	}
+	span.End()
+
+	dh.active.Add(1)
+	metrics.ActiveDownloads.Inc()
+	defer func() {
+		dh.active.Add(-1)
+		metrics.ActiveDownloads.Dec()
+	}()
+
+	start := time.Now()
+	event := webhook.Event{Type: webhook.DownloadStarted, LinkID: params.ID, Url: common.RedactURL(params.Url), Filename: params.Filename}
+	dh.notify(r, event)
+
+	source := metrics.SourceUrl
+	var written int64
+	if parseUrl.Scheme == "file" {
+		source = metrics.SourceFile
+		downPath, _ := url.QueryUnescape(parseUrl.RequestURI())
+		version := parseUrl.Query().Get("version")
+		if version != "" {
+			// 指定版本时查询参数不属于文件路径
+			downPath = parseUrl.Path
+		}
+		written = dh.downloadFile(rec, r, dh.files, downPath, version, params.Filename)
+	} else if parseUrl.Scheme == "webdav" {
+		// 分享的 WebDAV 文件计入 webdav 来源
+		source = metrics.SourceWebDav
+		written = dh.downloadFile(rec, r, *dh.davFiles.Load(), parseUrl.Path, parseUrl.Query().Get("version"), params.Filename)
+	} else {
+		written = dh.downloadUrl(rec, r, settings, params.Url, params.Filename)
+	}
+
+	event.Status, event.Bytes, event.DurationMs = rec.Status(), rec.Written(), time.Since(start).Milliseconds()
+	if written >= 0 {
+		event.Type = webhook.DownloadCompleted
+	} else {
+		event.Type = webhook.DownloadFailed
+		event.Reason = http.StatusText(event.Status)
+		if event.Status < http.StatusBadRequest {
+			// 响应头已发送，传输过程中中断
+			event.Reason = "transfer interrupted"
+		}
+	}
+	dh.notify(r, event)
+	if params.ID != "" {
+		dh.links.Load().AddBytes(params.ID, rec.Written())
+	}
+	metrics.BytesSent.WithLabelValues(source).Add(float64(rec.Written()))
+	if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+		// 条件请求，返回 304 视为缓存命中
+		result := "miss"
+		if rec.Status() == http.StatusNotModified {
+			result = "hit"
+		}
+		metrics.CacheRequests.WithLabelValues(result).Inc()
+	}
+	slog.Debug(fmt.Sprintf("Download: %s - %s | Size: %s", common.RedactURL(params.Url), params.Filename, common.FormatBytes(rec.Written())))
+}
+
+// 请求参数不合法时返回的错误，包含响应状态码
+type requestError struct {
+	code int
+	msg  string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+// 解析并校验下载参数：解密 enc 或校验签名、校验链接格式与有效期
+// 校验失败时仍返回已解析的参数，用于记录日志
+func (dh *DownloadHandler) resolveParams(r *http.Request, settings *downloadSettings) (*DownloadParams, *url.URL, *requestError) {
 	params := &DownloadParams{}
 	// 加密参数
 	enc := r.URL.Query().Get("enc")
 	if enc != "" {
-		encBytes, err := base64.RawURLEncoding.DecodeString(enc)
-		if err != nil {
-			slog.Error(fmt.Sprintf("enc base64 decode error: %v", err))
-			http.Error(w, "Invalid enc", http.StatusBadRequest)
-			return
-		}
-		encDecrypt, err := common.Decrypt(dh.signKey, encBytes)
-		if err != nil {
-			slog.Error(fmt.Sprintf("enc decrypt error: %v", err))
-			http.Error(w, "Invalid enc", http.StatusBadRequest)
-			return
+		var reason string
+		var err error
+		if params, reason, err = decodeEnc(r.Context(), settings.signKey, enc); err != nil {
+			slog.Error(err.Error())
+			metrics.SignatureFailures.WithLabelValues(reason).Inc()
+			dh.notify(r, webhook.Event{Type: webhook.SignatureRejected, Reason: reason})
+			return params, nil, &requestError{code: http.StatusBadRequest, msg: "Invalid enc"}
 		}
-		if err = json.Unmarshal(encDecrypt, &params); err != nil {
-			slog.Error(fmt.Sprintf("enc json unmarshal error: %v", err))
-			http.Error(w, "Invalid enc", http.StatusBadRequest)
-			return
+		if params.ID != "" {
+			// 服务端保存的链接以保存的内容为准，不信任 enc 中的参数
+			store := dh.links.Load()
+			if store == nil {
+				return params, nil, linkError(links.ErrNotFound)
+			}
+			link, ok := store.Get(params.ID)
+			if !ok {
+				return params, nil, linkError(links.ErrNotFound)
+			}
+			params.Url, params.Filename, params.Expire = link.Url, link.Filename, ""
+			if link.Expire > 0 {
+				params.Expire = strconv.FormatInt(link.Expire, 10)
+			}
 		}
 	} else {
 		params.Url = r.URL.Query().Get("url")
@@ -161,39 +476,40 @@ func (dh *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if params.Url == "" {
 		// 缺少必须参数
-		http.Error(w, "Missing required parameter: url", http.StatusBadRequest)
-		return
+		return params, nil, &requestError{code: http.StatusBadRequest, msg: "Missing required parameter: url"}
+	}
+	if params.ID != "" {
+		accesslog.SetLinkID(r.Context(), params.ID)
+	} else if enc != "" {
+		accesslog.SetLinkID(r.Context(), linkID(enc))
+	} else if params.Sign != "" {
+		accesslog.SetLinkID(r.Context(), linkID(params.Sign))
 	}
 
-	if enc == "" && dh.signKey != "" {
-		// 需要签名校验的参数，为空的参数不校验 sign = md5(filename + "|" + url + "|" + expire + "|" + <your_sign_key>)
-		var needSignParams []string
-		if params.Filename != "" {
-			needSignParams = append(needSignParams, params.Filename)
-		}
-		needSignParams = append(needSignParams, params.Url)
-		if params.Expire != "" {
-			needSignParams = append(needSignParams, params.Expire)
-		}
-		needSignParams = append(needSignParams, dh.signKey)
-
-		if strings.ToLower(params.Sign) != common.CalculateMD5(strings.Join(needSignParams, "|")) {
+	if enc == "" && settings.signKey != "" {
+		if !signMatches(settings.signKey, params) {
 			// 数据签名不匹配，返回错误信息
-			http.Error(w, "Invalid sign", http.StatusBadRequest)
-			return
+			metrics.SignatureFailures.WithLabelValues(metrics.SignatureMismatch).Inc()
+			dh.notify(r, webhook.Event{Type: webhook.SignatureRejected, Reason: metrics.SignatureMismatch})
+			return params, nil, &requestError{code: http.StatusBadRequest, msg: "Invalid sign"}
 		}
 	}
 
 	parseUrl, err := url.Parse(params.Url)
 	if err != nil {
-		http.Error(w, "Failed to parse url", http.StatusBadRequest)
-		return
+		return params, nil, &requestError{code: http.StatusBadRequest, msg: "Failed to parse url"}
 	}
 
 	// 校验url是否合法
-	if parseUrl.Scheme != "http" && parseUrl.Scheme != "https" && parseUrl.Scheme != "file" {
-		http.Error(w, "Invalid url", http.StatusBadRequest)
-		return
+	if parseUrl.Scheme != "http" && parseUrl.Scheme != "https" && parseUrl.Scheme != "file" && parseUrl.Scheme != "webdav" {
+		return params, nil, &requestError{code: http.StatusBadRequest, msg: "Invalid url"}
+	}
+	// WebDAV 文件需要认证才能访问，只能通过服务端保存的链接下载，且链接必须使用签名key加密
+	if parseUrl.Scheme == "webdav" && (params.ID == "" || settings.signKey == "" || dh.davFiles.Load() == nil) {
+		return params, nil, &requestError{code: http.StatusBadRequest, msg: "Invalid url"}
+	}
+	if parseUrl.Scheme != "file" && parseUrl.Scheme != "webdav" {
+		accesslog.SetUpstream(r.Context(), parseUrl.Host)
 	}
 
 	if params.Filename == "" {
@@ -209,106 +525,242 @@ func (dh *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// 校验下载链接是否过期
 		timestamp, err := strconv.ParseInt(params.Expire, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid expire parameter: must be a valid UNIX timestamp", http.StatusBadRequest)
-			return
+			return params, nil, &requestError{code: http.StatusBadRequest, msg: "Invalid expire parameter: must be a valid UNIX timestamp"}
 		}
 		expireTime := time.Unix(timestamp, 0)
 		currentTime := time.Now()
 		if currentTime.After(expireTime) {
-			http.Error(w, "Link has expired", http.StatusForbidden)
-			return
+			metrics.ExpiredLinks.Inc()
+			dh.notify(r, webhook.Event{Type: webhook.LinkExpired, LinkID: params.ID, Url: common.RedactURL(params.Url), Filename: params.Filename})
+			return params, nil, &requestError{code: http.StatusForbidden, msg: "Link has expired"}
 		}
 	}
+	if params.ID != "" {
+		if reqErr := dh.useLink(r, params.ID); reqErr != nil {
+			return params, nil, reqErr
+		}
+	}
+	return params, parseUrl, nil
+}
 
-	var written int64
-	if parseUrl.Scheme == "file" {
-		downPath, _ := url.QueryUnescape(parseUrl.RequestURI())
-		written = dh.downloadFile(w, r, downPath, params.Filename)
-	} else {
-		written = dh.downloadUrl(w, r, params.Url, params.Filename)
+// 解密 enc 参数，失败时返回对应的签名失败原因
+func decodeEnc(ctx context.Context, signKey, enc string) (*DownloadParams, string, error) {
+	params := &DownloadParams{}
+	encBytes, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return params, metrics.SignatureEncDecode, fmt.Errorf("enc base64 decode error: %w", err)
 	}
-	if written >= 0 {
-		// 打印下载日志 输出时间、访问UA、文件名、下载地址、文件大小
-		slog.Info(fmt.Sprintf("%s - %s | Size: %s | IP: %s | UA: %s",
-			params.Url, params.Filename,
-			common.FormatBytes(written),
-			common.GetRealIP(r),
-			r.Header.Get("User-Agent")))
+	_, decryptSpan := tracing.Tracer().Start(ctx, "decrypt")
+	encDecrypt, err := common.Decrypt(signKey, encBytes)
+	decryptSpan.End()
+	if err != nil {
+		return params, metrics.SignatureEncDecrypt, fmt.Errorf("enc decrypt error: %w", err)
+	}
+	if err = json.Unmarshal(encDecrypt, params); err != nil {
+		return params, metrics.SignatureEncPayload, fmt.Errorf("enc json unmarshal error: %w", err)
+	}
+	return params, "", nil
+}
+
+// 校验参数签名，为空的参数不校验 sign = md5(filename + "|" + url + "|" + expire + "|" + <your_sign_key>)
+func signMatches(signKey string, params *DownloadParams) bool {
+	var needSignParams []string
+	if params.Filename != "" {
+		needSignParams = append(needSignParams, params.Filename)
+	}
+	needSignParams = append(needSignParams, params.Url)
+	if params.Expire != "" {
+		needSignParams = append(needSignParams, params.Expire)
+	}
+	needSignParams = append(needSignParams, signKey)
+	return strings.ToLower(params.Sign) == common.CalculateMD5(strings.Join(needSignParams, "|"))
+}
+
+// 校验服务端保存的链接是否可用并记录使用次数
+// 每次开始下载都计数，同一客户端IP断点续传（带 Range 头）的后续请求不重复计数
+func (dh *DownloadHandler) useLink(r *http.Request, id string) *requestError {
+	store := dh.links.Load()
+	if store == nil {
+		return &requestError{code: http.StatusForbidden, msg: "Link is not available"}
+	}
+	return linkError(store.Use(id, common.GetRealIP(r), r.Header.Get("Range") != ""))
+}
+
+// 链接不可用的原因转换为请求错误，可用时返回 nil
+func linkError(err error) *requestError {
+	switch {
+	case errors.Is(err, links.ErrRevoked):
+		return &requestError{code: http.StatusForbidden, msg: "Link has been revoked"}
+	case errors.Is(err, links.ErrExhausted):
+		return &requestError{code: http.StatusForbidden, msg: "Link has reached its max uses"}
+	case err != nil:
+		return &requestError{code: http.StatusForbidden, msg: "Link is not available"}
 	}
+	return nil
+}
+
+// 下载链接标识，取 enc 或签名的摘要，避免在日志中暴露原始参数
+func linkID(value string) string {
+	return common.CalculateMD5(value)[:16]
+}
+
+// 请求访问的下载链接标识，未使用 enc 或签名时为空
+func requestLinkID(r *http.Request) string {
+	query := r.URL.Query()
+	if enc := query.Get("enc"); enc != "" {
+		return linkID(enc)
+	} else if sign := query.Get("sign"); sign != "" {
+		return linkID(sign)
+	}
+	return ""
 }
 
 // 下载远程文件
-func (dh *DownloadHandler) downloadUrl(w http.ResponseWriter, r *http.Request, downUrl string, filename string) int64 {
+func (dh *DownloadHandler) downloadUrl(w http.ResponseWriter, r *http.Request, settings *downloadSettings, downUrl string, filename string) int64 {
 	// 发起GET请求
 	request, err := http.NewRequestWithContext(r.Context(), http.MethodGet, downUrl, nil)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create request: %v", err), http.StatusInternalServerError)
 		return -1
 	}
+	ctx, span := tracing.StartUpstream(request.Context(), request.Method, request.URL)
+	defer span.End()
+	// 记录上游首字节耗时，发生重定向时以第一次响应为准
+	start := time.Now()
+	var ttfb time.Duration
+	ctx = httptrace.WithClientTrace(ctx, tracing.ClientTrace(ctx))
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			if ttfb == 0 {
+				ttfb = time.Since(start)
+			}
+		},
+	})
+	request = request.WithContext(ctx)
 	// 透传请求头给目标地址
 	for header, values := range r.Header {
-		if dh.forwardReqHeaders[header] {
+		if settings.forwardReqHeaders[header] {
 			for _, value := range values {
 				request.Header.Add(header, value)
 			}
 		}
 	}
+	// 将链路上下文传递给上游
+	tracing.Inject(ctx, request.Header)
 	// 发送 HTTP 请求
-	response, err := dh.client.Do(request)
+	response, err := settings.client.Do(request)
 	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// url.Error 会携带完整的请求地址，仅保留底层错误
+			err = urlErr.Err
+		}
+		slog.Error(fmt.Sprintf("Request upstream error: %s - %v", common.RedactURL(downUrl), err))
+		tracing.Error(span, err)
 		http.Error(w, fmt.Sprintf("Failed to send request: %v", err), http.StatusInternalServerError)
 		return -1
 	}
 	defer func(Body io.ReadCloser) {
 		_ = Body.Close()
+		metrics.UpstreamLatency.Observe(time.Since(start).Seconds())
 	}(response.Body)
+	if ttfb > 0 {
+		metrics.UpstreamTTFB.Observe(ttfb.Seconds())
+	}
+	tracing.SetStatusCode(span, response.StatusCode)
 
-	// 透传响应头给客户端
-	for header, values := range response.Header {
-		if dh.forwardRespHeaders[header] {
-			for _, value := range values {
-				w.Header().Add(header, value)
+	if finalUrl := response.Request.URL; finalUrl.String() != request.URL.String() {
+		slog.Info(fmt.Sprintf("Redirected: %s -> %s", common.RedactURL(downUrl), common.RedactURL(finalUrl.String())))
+	}
+
+	if location := response.Header.Get("Location"); location != "" &&
+		response.StatusCode >= 300 && response.StatusCode < 400 && response.StatusCode != http.StatusNotModified {
+		// 未跟随的重定向，将3xx响应透传给客户端
+		if locationUrl, err := response.Request.URL.Parse(location); err == nil {
+			location = locationUrl.String()
+		}
+		w.Header().Set("Location", location)
+		w.WriteHeader(response.StatusCode)
+		return 0
+	}
+
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		// 条件请求命中缓存，仅透传缓存相关的响应头
+		for _, header := range notModifiedHeaders {
+			if values := response.Header.Values(header); len(values) > 0 {
+				w.Header()[header] = values
 			}
 		}
+		w.WriteHeader(http.StatusNotModified)
+		return 0
+	case http.StatusPartialContent:
+		if err := validatePartialContent(r, response); err != nil {
+			slog.Error(fmt.Sprintf("Invalid partial content from upstream: %s - %v", common.RedactURL(downUrl), err))
+			http.Error(w, "Upstream returned invalid partial content", http.StatusBadGateway)
+			return -1
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		if contentRange := response.Header.Get("Content-Range"); contentRange != "" {
+			w.Header().Set("Content-Range", contentRange)
+		}
 	}
 
 	if response.StatusCode < 200 || response.StatusCode >= 300 {
 		// 请求下载链接状态码不为成功就不进行后续操作
-		http.Error(w, fmt.Sprintf("Request failed: %s - %s", downUrl, response.Status), response.StatusCode)
+		dh.upstreamError(w, settings, response, downUrl)
 		return -1
 	}
 
+	// 透传响应头给客户端
+	for header, values := range response.Header {
+		if settings.forwardRespHeaders[header] {
+			for _, value := range values {
+				w.Header().Add(header, value)
+			}
+		}
+	}
+
 	// 设置响应头
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
 	if ct := w.Header().Get("Content-Type"); ct == "" {
 		// 如果响应头没有Content-Type，则默认为二进制流
 		w.Header().Set("Content-Type", "application/octet-stream")
 	}
-	// 将响应体写入到ResponseWriter
-	written, err := io.Copy(w, response.Body)
+	var written int64
+	if br, ok := sliceRange(r, response); ok && response.StatusCode == http.StatusOK {
+		// 上游忽略了 Range 请求头，由本地截取请求的范围
+		written, err = writeSlicedRange(w, response, br)
+	} else {
+		w.WriteHeader(response.StatusCode)
+		// 将响应体写入到ResponseWriter
+		written, err = io.Copy(w, response.Body)
+	}
 	if err != nil {
 		slog.Error(fmt.Sprintf("Copy url data error: %v", err))
+		tracing.Error(span, err)
 		return -1
 	}
 	return written
 }
 
-// 下载本地文件
-func (dh *DownloadHandler) downloadFile(w http.ResponseWriter, r *http.Request, downPath string, filename string) int64 {
+// 下载存储中的文件，version 不为空时下载 WebDAV 保存的历史版本
+func (dh *DownloadHandler) downloadFile(w http.ResponseWriter, r *http.Request, files storage.Storage, downPath, version, filename string) int64 {
 	if downPath == "" {
 		http.Error(w, "Invalid file path", http.StatusBadRequest)
 		return -1
 	}
+	if version != "" {
+		return dh.downloadVersion(w, r, files, downPath, version, filename)
+	}
 
-	// 构造文件的完整路径，需要对传入path进行clean，防止路径穿越
-	filePath := filepath.Join(dh.dir, filepath.Clean(downPath))
-	// 打开文件
-	file, err := os.Open(filePath)
+	// 存储会对路径进行clean，不会穿越到根目录之外
+	file, err := files.OpenFile(r.Context(), downPath, os.O_RDONLY, 0)
 	if err != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return -1
 	}
-	defer func(file *os.File) {
+	defer func(file webdav.File) {
 		_ = file.Close()
 	}(file)
 
@@ -317,6 +769,10 @@ func (dh *DownloadHandler) downloadFile(w http.ResponseWriter, r *http.Request,
 		http.Error(w, "File stat error", http.StatusInternalServerError)
 		return -1
 	}
+	if fileInfo.IsDir() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return -1
+	}
 
 	// 设置响应头
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
@@ -325,6 +781,27 @@ func (dh *DownloadHandler) downloadFile(w http.ResponseWriter, r *http.Request,
 	return fileInfo.Size()
 }
 
+// 下载文件的历史版本，版本目录位于文件的某一级上级目录中
+func (dh *DownloadHandler) downloadVersion(w http.ResponseWriter, r *http.Request, files storage.Storage, downPath, version, filename string) int64 {
+	number, err := strconv.Atoi(version)
+	if err != nil || number <= 0 {
+		http.Error(w, "Invalid version: must be a positive integer", http.StatusBadRequest)
+		return -1
+	}
+	file, v, err := versions.Find(r.Context(), files, downPath, number)
+	if err != nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return -1
+	}
+	defer func(file webdav.File) {
+		_ = file.Close()
+	}(file)
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	http.ServeContent(w, r, path.Base(downPath), v.ModTime, file)
+	return v.Size
+}
+
 // 返回JSON格式的响应
 func (dh *DownloadHandler) jsonResponse(w http.ResponseWriter, code int, msg string, data any) error {
 	w.Header().Set("Content-Type", "application/json")
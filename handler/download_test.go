@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/junlongzzz/file-download-agent/storage"
+	"github.com/junlongzzz/file-download-agent/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 使用内存导出器记录 span，测试结束后恢复全局配置
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		_ = provider.Shutdown(t.Context())
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return exporter
+}
+
+func TestDownloadUrlTracing(t *testing.T) {
+	exporter := recordSpans(t)
+
+	var traceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer upstream.Close()
+
+	dh := NewDownloadHandler(storage.NewMemory(), "")
+	server := httptest.NewServer(tracing.Middleware(dh))
+	defer server.Close()
+
+	// 客户端携带的链路上下文
+	const clientTraceID, clientSpanID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	downUrl := upstream.URL + "/file.txt?token=secret"
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/download?url="+url.QueryEscape(downUrl), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Traceparent", "00-"+clientTraceID+"-"+clientSpanID+"-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Fatalf("download = %d %q, want 200 hello", resp.StatusCode, body)
+	}
+
+	var serverSpan, upstreamSpan *tracetest.SpanStub
+	spans := exporter.GetSpans()
+	for i := range spans {
+		switch {
+		case spans[i].SpanKind == trace.SpanKindServer:
+			serverSpan = &spans[i]
+		case spans[i].Name == "upstream GET":
+			upstreamSpan = &spans[i]
+		}
+	}
+	if serverSpan == nil || upstreamSpan == nil {
+		t.Fatalf("missing server or upstream span, got %d spans", len(spans))
+	}
+
+	// 服务端 span 继承客户端的链路，上游 span 是其子 span
+	if got := serverSpan.SpanContext.TraceID().String(); got != clientTraceID {
+		t.Errorf("server span trace id = %s, want %s", got, clientTraceID)
+	}
+	if got := serverSpan.Parent.SpanID().String(); got != clientSpanID {
+		t.Errorf("server span parent = %s, want %s", got, clientSpanID)
+	}
+	if upstreamSpan.SpanKind != trace.SpanKindClient {
+		t.Errorf("upstream span kind = %v, want client", upstreamSpan.SpanKind)
+	}
+	if upstreamSpan.Parent.SpanID() != serverSpan.SpanContext.SpanID() {
+		t.Errorf("upstream span parent = %s, want server span %s", upstreamSpan.Parent.SpanID(), serverSpan.SpanContext.SpanID())
+	}
+	for _, attr := range upstreamSpan.Attributes {
+		switch attr.Key {
+		case "url.full":
+			if strings.Contains(attr.Value.AsString(), "secret") {
+				t.Errorf("url.full leaks the query: %s", attr.Value.AsString())
+			}
+		case "http.response.status_code":
+			if attr.Value.AsInt64() != http.StatusOK {
+				t.Errorf("http.response.status_code = %d, want 200", attr.Value.AsInt64())
+			}
+		}
+	}
+
+	// 上游收到的 traceparent 指向上游 span
+	want := "00-" + clientTraceID + "-" + upstreamSpan.SpanContext.SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("upstream traceparent = %q, want %q", traceparent, want)
+	}
+}
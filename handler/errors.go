@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/junlongzzz/file-download-agent/common"
+)
+
+// UpstreamErrorMode 上游返回错误状态码时的响应方式
+type UpstreamErrorMode string
+
+const (
+	UpstreamErrorPlain       UpstreamErrorMode = "plain"       // 纯文本错误信息
+	UpstreamErrorPassthrough UpstreamErrorMode = "passthrough" // 透传上游状态码与响应体（限制大小）
+	UpstreamErrorHTML        UpstreamErrorMode = "html"        // 模板渲染的错误页面
+	UpstreamErrorJSON        UpstreamErrorMode = "json"        // JSON 格式错误信息
+)
+
+// 透传上游错误响应体的默认大小上限
+const defaultUpstreamErrorBodyLimit = 64 * 1024
+
+// 默认的上游错误页面模板
+const defaultUpstreamErrorTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Status}}</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; margin: 0; padding: 40px 20px; background: #f5f5f5; color: #333; }
+        .container { max-width: 640px; margin: 0 auto; background: #fff; border-radius: 8px; padding: 24px 32px; box-shadow: 0 2px 8px rgba(0, 0, 0, 0.1); }
+        h1 { font-size: 22px; margin-top: 0; }
+        code { word-break: break-all; color: #666; }
+    </style>
+</head>
+<body>
+<div class="container">
+    <h1>{{.Status}}</h1>
+    <p>The upstream server responded with an error.</p>
+    <p><code>{{.Url}}</code></p>
+</div>
+</body>
+</html>
+`
+
+// 上游错误页面模板渲染数据
+type upstreamErrorData struct {
+	Code   int    // 上游状态码
+	Status string // 上游状态文本，例如 404 Not Found
+	Url    string // 已隐藏敏感信息的上游地址
+}
+
+// ParseUpstreamErrorMode 解析上游错误响应方式，为空时使用 plain
+func ParseUpstreamErrorMode(s string) (UpstreamErrorMode, error) {
+	switch mode := UpstreamErrorMode(strings.ToLower(s)); mode {
+	case "":
+		return UpstreamErrorPlain, nil
+	case UpstreamErrorPlain, UpstreamErrorPassthrough, UpstreamErrorHTML, UpstreamErrorJSON:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid upstream error mode %q: must be one of plain, passthrough, html, json", s)
+	}
+}
+
+// SetUpstreamError 设置上游错误响应方式
+// bodyLimit: passthrough 模式下透传响应体的最大字节数，<=0 时使用默认值
+// templateFile: html 模式下的自定义页面模板文件，为空时使用内置模板
+func (dh *DownloadHandler) SetUpstreamError(mode UpstreamErrorMode, bodyLimit int64, templateFile string) error {
+	if bodyLimit <= 0 {
+		bodyLimit = defaultUpstreamErrorBodyLimit
+	}
+	tmpl, err := parseUpstreamErrorTemplate(templateFile)
+	if err != nil {
+		return err
+	}
+	return dh.update(func(s *downloadSettings) error {
+		s.upstreamErrorMode = mode
+		s.upstreamErrorBodyLimit = bodyLimit
+		s.upstreamErrorTemplate = tmpl
+		return nil
+	})
+}
+
+// 解析上游错误页面模板，templateFile 为空时使用内置模板
+func parseUpstreamErrorTemplate(templateFile string) (*template.Template, error) {
+	tmpl := template.New("upstream_error")
+	if templateFile == "" {
+		return template.Must(tmpl.Parse(defaultUpstreamErrorTemplate)), nil
+	}
+	content, err := os.ReadFile(templateFile)
+	if err != nil {
+		return nil, fmt.Errorf("read upstream error template error: %v", err)
+	}
+	if _, err = tmpl.Parse(string(content)); err != nil {
+		return nil, fmt.Errorf("parse upstream error template error: %v", err)
+	}
+	return tmpl, nil
+}
+
+// 上游返回非成功状态码时，按配置的方式响应客户端
+// 所有方式输出的上游地址均已隐藏查询参数与凭证，发生重定向时输出最终请求的地址
+func (dh *DownloadHandler) upstreamError(w http.ResponseWriter, settings *downloadSettings, response *http.Response, downUrl string) {
+	finalUrl := downUrl
+	if response.Request != nil && response.Request.URL != nil {
+		finalUrl = response.Request.URL.String()
+	}
+	data := upstreamErrorData{
+		Code:   response.StatusCode,
+		Status: response.Status,
+		Url:    common.RedactURL(finalUrl),
+	}
+	slog.Warn(fmt.Sprintf("Upstream error: %s - %s", data.Url, data.Status))
+
+	switch settings.upstreamErrorMode {
+	case UpstreamErrorPassthrough:
+		reader, err := decodeUpstreamBody(response)
+		if err != nil {
+			// 无法解码时不能替换其中的地址，只返回通用的错误信息
+			slog.Warn(fmt.Sprintf("Decode upstream error body error: %v", err))
+			http.Error(w, fmt.Sprintf("Request failed: %s - %s", data.Url, data.Status), response.StatusCode)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(reader, settings.upstreamErrorBodyLimit))
+		if err != nil {
+			slog.Error(fmt.Sprintf("Read upstream error body error: %v", err))
+		}
+		body = sanitizeUpstreamBody(body, downUrl, finalUrl)
+		for header, values := range response.Header {
+			// 编码与长度相关的头会因截断和替换而失效，不进行透传
+			if settings.forwardRespHeaders[header] && header != "Content-Length" && header != "Content-Encoding" && header != "Content-Range" {
+				for _, value := range values {
+					w.Header().Add(header, value)
+				}
+			}
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.WriteHeader(response.StatusCode)
+		_, _ = w.Write(body)
+	case UpstreamErrorHTML:
+		var buf bytes.Buffer
+		if err := settings.upstreamErrorTemplate.Execute(&buf, data); err != nil {
+			slog.Error(fmt.Sprintf("Render upstream error template error: %v", err))
+			http.Error(w, fmt.Sprintf("Request failed: %s - %s", data.Url, data.Status), response.StatusCode)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(response.StatusCode)
+		_, _ = w.Write(buf.Bytes())
+	case UpstreamErrorJSON:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(response.StatusCode)
+		_ = dh.jsonResponse(w, response.StatusCode, "Request failed", map[string]any{
+			"url":    data.Url,
+			"status": data.Status,
+		})
+	default:
+		http.Error(w, fmt.Sprintf("Request failed: %s - %s", data.Url, data.Status), response.StatusCode)
+	}
+}
+
+// 返回解压后的上游响应体，支持 gzip 与 deflate，其他编码返回错误
+// 转发了客户端的 Accept-Encoding 时，http.Client 不会自动解压
+func decodeUpstreamBody(response *http.Response) (io.Reader, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return response.Body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(response.Body)
+	case "deflate":
+		return zlib.NewReader(response.Body)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// 查询参数值短于该长度时不替换，避免把响应体中常见的短文本（如 1、true）替换掉
+// 签名、凭证等敏感参数都远长于该长度
+const minRedactedQueryValueLen = 6
+
+// 替换上游错误响应体中出现的地址、查询字符串与各个查询参数值，避免回显签名信息
+// urls 为原始请求地址与重定向后的最终地址，同时匹配 URL 编码与 HTML 转义后的形式
+func sanitizeUpstreamBody(body []byte, urls ...string) []byte {
+	replacements := make(map[string]string)
+	add := func(s, replacement string) {
+		for _, form := range []string{s, template.HTMLEscapeString(s), url.QueryEscape(s)} {
+			if form != "" {
+				replacements[form] = replacement
+			}
+		}
+	}
+	for _, rawUrl := range urls {
+		add(rawUrl, common.RedactURL(rawUrl))
+		u, err := url.Parse(rawUrl)
+		if err != nil {
+			continue
+		}
+		if password, ok := u.User.Password(); ok {
+			add(password, "redacted")
+		}
+		if u.RawQuery == "" {
+			continue
+		}
+		add(u.RawQuery, "redacted")
+		if unescaped, err := url.QueryUnescape(u.RawQuery); err == nil {
+			add(unescaped, "redacted")
+		}
+		for _, values := range u.Query() {
+			for _, value := range values {
+				if len(value) >= minRedactedQueryValueLen {
+					add(value, "redacted")
+				}
+			}
+		}
+	}
+	// 先替换较长的字符串，避免其中的部分被提前替换后无法匹配
+	olds := slices.SortedFunc(maps.Keys(replacements), func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b))
+	})
+	for _, old := range olds {
+		body = bytes.ReplaceAll(body, []byte(old), []byte(replacements[old]))
+	}
+	return body
+}
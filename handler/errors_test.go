@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/junlongzzz/file-download-agent/storage"
+)
+
+func TestUpstreamErrorRedactsSignedUrls(t *testing.T) {
+	const (
+		token     = "origtoken123"
+		signature = "0123456789abcdef0123456789abcdef"
+		secToken  = "FwoGZXIvYXdzEJr//////////wEaDH+sessiontoken"
+	)
+	finalQuery := url.Values{
+		"X-Amz-Signature":      {signature},
+		"X-Amz-Security-Token": {secToken},
+		"X-Amz-Expires":        {"3600"},
+	}.Encode()
+	var finalUrl string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/file" {
+			http.Redirect(w, r, "/signed/file?"+finalQuery, http.StatusFound)
+			return
+		}
+		// 模拟 S3 回显签名与请求地址的错误响应
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprintf(w, "<Error><SignatureProvided>%s</SignatureProvided><Token>%s</Token>"+
+			"<Url>%s</Url><Escaped>%s</Escaped><Original>%s</Original><Expires>3600</Expires></Error>",
+			signature, secToken, finalUrl, strings.ReplaceAll(finalUrl, "&", "&amp;"), token)
+	}))
+	defer upstream.Close()
+	finalUrl = upstream.URL + "/signed/file?" + finalQuery
+	downUrl := upstream.URL + "/file?token=" + token
+
+	dh := NewDownloadHandler(storage.NewMemory(), "")
+	download := func(mode UpstreamErrorMode) (int, string) {
+		t.Helper()
+		if err := dh.SetUpstreamError(mode, 0, ""); err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		dh.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/download?url="+url.QueryEscape(downUrl), nil))
+		body, _ := io.ReadAll(rec.Body)
+		return rec.Code, string(body)
+	}
+
+	code, body := download(UpstreamErrorPassthrough)
+	if code != http.StatusForbidden {
+		t.Fatalf("passthrough status = %d, want 403", code)
+	}
+	for _, secret := range []string{token, signature, secToken, url.QueryEscape(secToken)} {
+		if strings.Contains(body, secret) {
+			t.Errorf("passthrough body leaks %q: %s", secret, body)
+		}
+	}
+	// 短参数值与最终地址的路径保留
+	if !strings.Contains(body, "<Expires>3600</Expires>") || !strings.Contains(body, upstream.URL+"/signed/file?redacted") {
+		t.Errorf("passthrough body = %s, want the redacted final url and short values kept", body)
+	}
+
+	code, body = download(UpstreamErrorJSON)
+	var resp struct {
+		Data struct {
+			Url string `json:"url"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("json body %q: %v", body, err)
+	}
+	if want := upstream.URL + "/signed/file?redacted"; code != http.StatusForbidden || resp.Data.Url != want {
+		t.Errorf("json = %d %q, want 403 with url %q", code, resp.Data.Url, want)
+	}
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/junlongzzz/file-download-agent/common"
+)
+
+type HealthHandler struct {
+	// 服务正在停止，等待进行中的下载完成
+	draining atomic.Bool
+	// 就绪检查配置，可热更新
+	readiness atomic.Pointer[ReadinessOptions]
+}
+
+// ReadinessOptions 就绪检查配置
+type ReadinessOptions struct {
+	Dirs            map[string]string // 需要可读写的目录，key 为检查项名称
+	MinFreeSpace    int64             // 目录所在磁盘最少可用空间，单位：字节，<=0 时不检查
+	UpstreamUrl     string            // 可选的上游探测地址，为空时不检查
+	UpstreamTimeout time.Duration     // 上游探测超时
+	Client          *http.Client      // 上游探测使用的http客户端，为空时使用默认客户端
+}
+
+// NewHealthHandler 创建Handler
+func NewHealthHandler() *HealthHandler {
+	hh := &HealthHandler{}
+	hh.readiness.Store(&ReadinessOptions{})
+	return hh
+}
+
+// SetDraining 设置服务是否正在停止
+func (hh *HealthHandler) SetDraining(draining bool) {
+	hh.draining.Store(draining)
+}
+
+// Draining 服务是否正在停止
+func (hh *HealthHandler) Draining() bool {
+	return hh.draining.Load()
+}
+
+// SetReadiness 设置就绪检查配置
+func (hh *HealthHandler) SetReadiness(opts ReadinessOptions) {
+	if opts.UpstreamTimeout <= 0 {
+		opts.UpstreamTimeout = 5 * time.Second
+	}
+	hh.readiness.Store(&opts)
+}
+
+// 健康检查处理函数，按路径区分 /healthz 存活检查、/readyz 就绪检查、/version 版本信息
+func (hh *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch r.URL.Path {
+	case "/readyz":
+		hh.ready(w, r)
+	case "/version":
+		hh.jsonResponse(w, http.StatusOK, map[string]any{
+			"version":    common.Version(),
+			"commit":     common.Commit(),
+			"build_date": common.BuildDate(),
+			"go":         runtime.Version(),
+			"os":         runtime.GOOS,
+			"arch":       runtime.GOARCH,
+		})
+	default:
+		// 存活检查，停止过程中返回 503
+		code, status := http.StatusOK, "ok"
+		if hh.Draining() {
+			code, status = http.StatusServiceUnavailable, "draining"
+		}
+		hh.jsonResponse(w, code, map[string]any{"status": status})
+	}
+}
+
+// 就绪检查，任一检查项失败时返回 503
+func (hh *HealthHandler) ready(w http.ResponseWriter, r *http.Request) {
+	if hh.Draining() {
+		hh.jsonResponse(w, http.StatusServiceUnavailable, map[string]any{"status": "draining"})
+		return
+	}
+
+	opts := hh.readiness.Load()
+	checks := make(map[string]string)
+	ready := true
+	check := func(name string, err error) {
+		if err != nil {
+			checks[name] = err.Error()
+			ready = false
+		} else {
+			checks[name] = "ok"
+		}
+	}
+	for name, dir := range opts.Dirs {
+		check(name, checkDir(dir))
+		if opts.MinFreeSpace > 0 {
+			check(name+"_space", checkFreeSpace(dir, opts.MinFreeSpace))
+		}
+	}
+	if opts.UpstreamUrl != "" {
+		check("upstream", checkUpstream(r.Context(), opts))
+	}
+
+	code, status := http.StatusOK, "ready"
+	if !ready {
+		code, status = http.StatusServiceUnavailable, "not ready"
+	}
+	hh.jsonResponse(w, code, map[string]any{"status": status, "checks": checks})
+}
+
+// 返回JSON格式的响应
+func (hh *HealthHandler) jsonResponse(w http.ResponseWriter, code int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+// 检查目录是否可读写
+func checkDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("not readable: %v", err)
+	}
+	_, err = f.Readdirnames(1)
+	_ = f.Close()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("not readable: %v", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".fda-readyz-*")
+	if err != nil {
+		return fmt.Errorf("not writable: %v", err)
+	}
+	_ = tmp.Close()
+	_ = os.Remove(tmp.Name())
+	return nil
+}
+
+// 检查目录所在磁盘的可用空间
+func checkFreeSpace(dir string, minFree int64) error {
+	free, err := common.DiskFree(dir)
+	if err != nil {
+		return err
+	}
+	if free < uint64(minFree) {
+		return fmt.Errorf("low disk space: %s free, %s required", common.FormatBytes(int64(free)), common.FormatBytes(minFree))
+	}
+	return nil
+}
+
+// 检查上游是否可访问，网关错误与服务不可用视为不可用
+// 上游可能不支持 HEAD，其余状态码均表示可访问
+func checkUpstream(ctx context.Context, opts *ReadinessOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.UpstreamTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, opts.UpstreamUrl, nil)
+	if err != nil {
+		return err
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("unreachable: %v", err)
+	}
+	_ = response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("unhealthy: %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"cmp"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/junlongzzz/file-download-agent/common"
+)
+
+//go:embed listing.html
+var listingTemplateText string
+
+// WebDAV 目录列表页面模板
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"size": common.FormatBytes,
+	"time": func(t time.Time) string { return t.Format(time.DateTime) },
+}).Parse(listingTemplateText))
+
+// 目录列表中的一项
+type listingEntry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"` // 相对于用户根目录的路径，目录以 / 结尾
+	Href    string    `json:"-"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// 面包屑导航中的一级目录
+type listingCrumb struct {
+	Name string
+	Href string
+}
+
+// 目录列表页面渲染数据
+type listingData struct {
+	Path     string
+	RootHref string         // 用户根目录的链接
+	Crumbs   []listingCrumb // 根目录之下的各级目录
+	Entries  []listingEntry
+	Query    string // 搜索关键字
+	Sort     string // name、size 或 time
+	Desc     bool
+	Total    int  // 搜索前的数量
+	Share    bool // 是否可以分享文件
+}
+
+// SortHref 返回按 key 排序的链接，已按 key 排序时切换顺序
+func (d *listingData) SortHref(key string) string {
+	query := url.Values{"sort": {key}}
+	if key == d.Sort && !d.Desc {
+		query.Set("order", "desc")
+	}
+	if d.Query != "" {
+		query.Set("q", d.Query)
+	}
+	return "?" + query.Encode()
+}
+
+// SortMark 返回排序方向标记
+func (d *listingData) SortMark(key string) string {
+	if key != d.Sort {
+		return ""
+	}
+	if d.Desc {
+		return "↓"
+	}
+	return "↑"
+}
+
+// 列出目录，支持参数 q 按名称搜索，sort 与 order 排序
+// 请求头 Accept 包含 application/json 时返回 JSON
+func (wh *WebDavHandler) serveListing(w http.ResponseWriter, r *http.Request, account *webDavAccount, name string) {
+	fs := account.handler.FileSystem
+	f, err := fs.OpenFile(r.Context(), name, os.O_RDONLY, 0)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	children, err := f.Readdir(-1)
+	_ = f.Close()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Read WebDAV directory error: %v", err))
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	dir := strings.TrimSuffix(path.Clean("/"+name), "/") + "/"
+	data := &listingData{
+		Path:  dir,
+		Query: strings.TrimSpace(query.Get("q")),
+		Sort:  query.Get("sort"),
+		Desc:  query.Get("order") == "desc",
+		Total: len(children),
+		Share: wh.canShare(),
+	}
+	if data.Sort != "size" && data.Sort != "time" {
+		data.Sort = "name"
+	}
+	keyword := strings.ToLower(data.Query)
+	data.Entries = make([]listingEntry, 0, len(children))
+	for _, child := range children {
+		if keyword != "" && !strings.Contains(strings.ToLower(child.Name()), keyword) {
+			continue
+		}
+		entry := listingEntry{
+			Name:    child.Name(),
+			Path:    dir + child.Name(),
+			Dir:     child.IsDir(),
+			Size:    child.Size(),
+			ModTime: child.ModTime(),
+		}
+		if entry.Dir {
+			entry.Path += "/"
+			entry.Size = 0
+		}
+		entry.Href = (&url.URL{Path: account.handler.Prefix + entry.Path}).EscapedPath()
+		data.Entries = append(data.Entries, entry)
+	}
+	sortListing(data.Entries, data.Sort, data.Desc)
+
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Cache-Control", "no-store")
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, map[string]any{"path": data.Path, "entries": data.Entries})
+		return
+	}
+
+	data.RootHref = account.handler.Prefix + "/"
+	var crumbs []listingCrumb
+	current := ""
+	for part := range strings.SplitSeq(strings.Trim(dir, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		current += "/" + part
+		crumbs = append(crumbs, listingCrumb{
+			Name: part,
+			Href: (&url.URL{Path: account.handler.Prefix + current + "/"}).EscapedPath(),
+		})
+	}
+	data.Crumbs = crumbs
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err = listingTemplate.Execute(w, data); err != nil {
+		slog.Error(fmt.Sprintf("Render WebDAV listing error: %v", err))
+	}
+}
+
+// 目录在前，再按指定字段排序，相同时按名称排序
+func sortListing(entries []listingEntry, key string, desc bool) {
+	slices.SortFunc(entries, func(a, b listingEntry) int {
+		if a.Dir != b.Dir {
+			if a.Dir {
+				return -1
+			}
+			return 1
+		}
+		var c int
+		switch key {
+		case "size":
+			c = cmp.Compare(a.Size, b.Size)
+		case "time":
+			c = a.ModTime.Compare(b.ModTime)
+		}
+		if c == 0 {
+			c = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		}
+		if desc {
+			return -c
+		}
+		return c
+	})
+}
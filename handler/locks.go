@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/junlongzzz/file-download-agent/davlock"
+	"golang.org/x/net/webdav"
+)
+
+// LockHandler 查看与强制解除 WebDAV 锁
+type LockHandler struct {
+	locks *davlock.LockSystem
+}
+
+// NewLockHandler 创建Handler
+func NewLockHandler(locks *davlock.LockSystem) *LockHandler {
+	return &LockHandler{locks: locks}
+}
+
+// 返回给管理接口的锁
+type adminLock struct {
+	davlock.Lock
+	Duration string `json:"duration"` // 锁定时长，永不过期时为 infinite
+	Depth    string `json:"depth"`    // 0 或 infinity
+}
+
+// GET 返回所有未过期的锁，DELETE ?token= 强制解除锁
+func (lh *LockHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch r.Method {
+	case http.MethodGet:
+		list := lh.locks.List()
+		result := make([]adminLock, 0, len(list))
+		for _, lock := range list {
+			depth := "infinity"
+			if lock.ZeroDepth {
+				depth = "0"
+			}
+			duration := "infinite"
+			if lock.Duration >= 0 {
+				duration = lock.Duration.String()
+			}
+			result = append(result, adminLock{Lock: lock, Duration: duration, Depth: depth})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"locks": result})
+	case http.MethodDelete:
+		// 兼容 Lock-Token 响应头中带尖括号的格式
+		token := strings.Trim(r.URL.Query().Get("token"), "<>")
+		if token == "" {
+			http.Error(w, "Missing required parameter: token", http.StatusBadRequest)
+			return
+		}
+		if err := lh.locks.Remove(token); errors.Is(err, webdav.ErrNoSuchLock) {
+			http.Error(w, "Lock not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			slog.Error(fmt.Sprintf("Remove webdav lock error: %v", err))
+			http.Error(w, "Failed to remove lock", http.StatusInternalServerError)
+			return
+		}
+		slog.Info(fmt.Sprintf("WebDAV lock removed: %s", token))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
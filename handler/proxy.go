@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ProxyRule 上游代理选择规则，按主机名匹配
+type ProxyRule struct {
+	Pattern string   // 主机名匹配模式，支持通配符，例如 *.example.com
+	Proxy   *url.URL // 代理地址，为 nil 时表示直连
+}
+
+// ParseProxyRules 解析代理规则文本
+// 格式: <pattern>=<target>[,<pattern>=<target>...]
+// target 可为 direct、http://[user:pass@]host:port、https://... 或 socks5://[user:pass@]host:port
+func ParseProxyRules(s string) ([]ProxyRule, error) {
+	var rules []ProxyRule
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pattern, target, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid proxy rule %q: missing '='", item)
+		}
+		rule, err := NewProxyRule(strings.TrimSpace(pattern), strings.TrimSpace(target))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// NewProxyRule 创建并校验单条代理规则
+func NewProxyRule(pattern, target string) (ProxyRule, error) {
+	pattern = strings.ToLower(pattern)
+	if err := ValidateHostPattern(pattern); err != nil {
+		return ProxyRule{}, fmt.Errorf("invalid proxy rule: %v", err)
+	}
+	if strings.EqualFold(target, "direct") {
+		return ProxyRule{Pattern: pattern}, nil
+	}
+	proxyUrl, err := url.Parse(target)
+	if err != nil {
+		return ProxyRule{}, fmt.Errorf("invalid proxy url %q: %v", target, err)
+	}
+	switch proxyUrl.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return ProxyRule{}, fmt.Errorf("invalid proxy url %q: unsupported scheme %q", target, proxyUrl.Scheme)
+	}
+	if proxyUrl.Host == "" {
+		return ProxyRule{}, fmt.Errorf("invalid proxy url %q: missing host", target)
+	}
+	return ProxyRule{Pattern: pattern, Proxy: proxyUrl}, nil
+}
+
+// Match 判断主机名是否匹配该规则
+func (pr ProxyRule) Match(host string) bool {
+	return matchHost(pr.Pattern, host)
+}
+
+// String 返回规则文本，代理凭证会被隐藏
+func (pr ProxyRule) String() string {
+	if pr.Proxy == nil {
+		return pr.Pattern + "=direct"
+	}
+	return pr.Pattern + "=" + pr.Proxy.Redacted()
+}
+
+// 根据规则生成 http.Transport 的 Proxy 函数
+// 按顺序匹配第一条命中的规则，均未命中时使用环境变量 HTTP_PROXY/HTTPS_PROXY/NO_PROXY
+func proxyFunc(rules []ProxyRule) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		host := req.URL.Hostname()
+		if ip := net.ParseIP(host); ip != nil {
+			host = ip.String()
+		}
+		for _, rule := range rules {
+			if rule.Match(host) {
+				// socks5 由 http.Transport 原生支持，直接返回代理地址即可
+				return rule.Proxy, nil
+			}
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+}
+
+// ValidateHostPattern 校验主机名匹配模式
+func ValidateHostPattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty host pattern")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("bad host pattern %q: %v", pattern, err)
+	}
+	return nil
+}
+
+// 判断主机名是否匹配模式，* 匹配所有主机
+func matchHost(pattern, host string) bool {
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	if pattern == "*" || pattern == host {
+		return true
+	}
+	matched, _ := path.Match(pattern, host)
+	return matched
+}
@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 304 响应允许透传的响应头 RFC 9110 15.4.5
+var notModifiedHeaders = []string{
+	"Cache-Control",
+	"Content-Location",
+	"Date",
+	"ETag",
+	"Expires",
+	"Last-Modified",
+	"Vary",
+}
+
+// 单个字节范围，对应 Range 请求头中的一项
+type byteRange struct {
+	start int64 // 起始位置，-1 表示后缀范围（最后 end 个字节）
+	end   int64 // 结束位置（包含），-1 表示直到末尾
+}
+
+// 解析 Range 请求头，仅支持 bytes 单位
+func parseRange(s string) ([]byteRange, error) {
+	unit, spec, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		return nil, errors.New("invalid range unit")
+	}
+	var ranges []byteRange
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(item, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid range %q", item)
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+		br := byteRange{start: -1, end: -1}
+		var err error
+		if first != "" {
+			if br.start, err = strconv.ParseInt(first, 10, 64); err != nil || br.start < 0 {
+				return nil, fmt.Errorf("invalid range %q", item)
+			}
+		}
+		if last != "" {
+			if br.end, err = strconv.ParseInt(last, 10, 64); err != nil || br.end < 0 {
+				return nil, fmt.Errorf("invalid range %q", item)
+			}
+		}
+		if (first == "" && last == "") || (br.start >= 0 && br.end >= 0 && br.end < br.start) {
+			return nil, fmt.Errorf("invalid range %q", item)
+		}
+		ranges = append(ranges, br)
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("empty range")
+	}
+	return ranges, nil
+}
+
+// 根据资源总大小计算实际的起止位置，size 为 -1 表示未知
+// 返回 ok=false 表示范围无法满足
+func (br byteRange) resolve(size int64) (start, end int64, ok bool) {
+	if br.start < 0 {
+		// 后缀范围必须已知资源大小
+		if size < 0 || br.end == 0 {
+			return 0, 0, false
+		}
+		start = max(size-br.end, 0)
+		return start, size - 1, true
+	}
+	start, end = br.start, br.end
+	if size >= 0 {
+		if start >= size {
+			return 0, 0, false
+		}
+		if end < 0 || end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+// 解析 Content-Range 响应头，格式: bytes <start>-<end>/<size|*>
+// size 未知时返回 -1
+func parseContentRange(s string) (start, end, size int64, err error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(s), "bytes ")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", s)
+	}
+	rng, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", s)
+	}
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid content range %q", s)
+		}
+	}
+	first, last, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", s)
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", s)
+	}
+	if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start || (size >= 0 && end >= size) {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", s)
+	}
+	return start, end, size, nil
+}
+
+// 校验上游 206 响应的 Content-Range 是否与请求的 Range 一致
+func validatePartialContent(r *http.Request, response *http.Response) error {
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" {
+		return errors.New("unexpected partial content for non-range request")
+	}
+	ranges, err := parseRange(rangeHeader)
+	if err != nil {
+		return err
+	}
+	if len(ranges) > 1 {
+		// 多范围请求由上游以 multipart/byteranges 响应，各分段自带 Content-Range，不逐一校验
+		if strings.HasPrefix(response.Header.Get("Content-Type"), "multipart/byteranges") {
+			return nil
+		}
+	}
+	start, end, size, err := parseContentRange(response.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	// 上游只返回单个范围时，必须落在请求的某一个范围内
+	for _, br := range ranges {
+		wantStart, wantEnd, ok := br.resolve(size)
+		if !ok || start != wantStart {
+			continue
+		}
+		// 上游可能截断过长的范围，只要不超出请求的结束位置即可
+		if wantEnd < 0 || end <= wantEnd {
+			if response.ContentLength >= 0 && response.ContentLength != end-start+1 {
+				return fmt.Errorf("content length %d does not match content range %d-%d", response.ContentLength, start, end)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("content range %d-%d does not match requested range %q", start, end, rangeHeader)
+}
+
+// 上游忽略了 Range 请求头并返回完整内容时，判断是否需要由本地截取范围
+// 返回需要截取的范围，ok=false 表示应返回完整内容
+func sliceRange(r *http.Request, response *http.Response) (br byteRange, ok bool) {
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" || response.Header.Get("Content-Range") != "" || response.ContentLength < 0 {
+		// 未知总大小时无法给出准确的 Content-Range，返回完整内容
+		return br, false
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" {
+		// If-Range 校验失败时应返回完整内容
+		etag := response.Header.Get("ETag")
+		if strings.HasPrefix(ifRange, `"`) {
+			if etag == "" || strings.HasPrefix(etag, "W/") || etag != ifRange {
+				return br, false
+			}
+		} else if lastModified := response.Header.Get("Last-Modified"); lastModified == "" || lastModified != ifRange {
+			return br, false
+		}
+	}
+	ranges, err := parseRange(rangeHeader)
+	if err != nil || len(ranges) != 1 {
+		// 无效或多范围请求直接返回完整内容，符合 RFC 9110 的要求
+		return br, false
+	}
+	return ranges[0], true
+}
+
+// 从完整响应体中截取范围写入客户端，返回写入的字节数
+// 范围无法满足时响应 416 并返回 -1，与其他失败的下载一样处理
+func writeSlicedRange(w http.ResponseWriter, response *http.Response, br byteRange) (int64, error) {
+	size := response.ContentLength
+	start, end, ok := br.resolve(size)
+	if !ok {
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return -1, nil
+	}
+	if start > 0 {
+		if _, err := io.CopyN(io.Discard, response.Body, start); err != nil {
+			return -1, err
+		}
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	w.WriteHeader(http.StatusPartialContent)
+	return io.Copy(w, io.LimitReader(response.Body, end-start+1))
+}
@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/junlongzzz/file-download-agent/storage"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []byteRange
+	}{
+		{"bytes=0-499", []byteRange{{0, 499}}},
+		{"bytes=500-", []byteRange{{500, -1}}},
+		{"bytes=-500", []byteRange{{-1, 500}}},
+		{"bytes=0-0, -1", []byteRange{{0, 0}, {-1, 1}}},
+		{"bytes= 0-1 ,, 5-9 ", []byteRange{{0, 1}, {5, 9}}},
+		{"items=0-1", nil},
+		{"bytes", nil},
+		{"bytes=", nil},
+		{"bytes=-", nil},
+		{"bytes=5", nil},
+		{"bytes=9-5", nil},
+		{"bytes=a-5", nil},
+		{"bytes=0-1,x", nil},
+	}
+	for _, tt := range tests {
+		got, err := parseRange(tt.header)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("parseRange(%q) = %v, want error", tt.header, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("parseRange(%q) = %v, %v, want %v", tt.header, got, err, tt.want)
+		}
+	}
+}
+
+func TestByteRangeResolve(t *testing.T) {
+	tests := []struct {
+		br         byteRange
+		size       int64
+		start, end int64
+		ok         bool
+	}{
+		{byteRange{0, 4}, 10, 0, 4, true},
+		{byteRange{5, -1}, 10, 5, 9, true},
+		{byteRange{5, 100}, 10, 5, 9, true},
+		// bytes=N- 超出文件末尾
+		{byteRange{10, -1}, 10, 0, 0, false},
+		{byteRange{20, 30}, 10, 0, 0, false},
+		// 后缀范围
+		{byteRange{-1, 3}, 10, 7, 9, true},
+		{byteRange{-1, 20}, 10, 0, 9, true},
+		{byteRange{-1, 0}, 10, 0, 0, false},
+		{byteRange{-1, 3}, -1, 0, 0, false},
+		// 未知大小时原样返回
+		{byteRange{5, -1}, -1, 5, -1, true},
+		{byteRange{0, 0}, 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, ok := tt.br.resolve(tt.size)
+		if start != tt.start || end != tt.end || ok != tt.ok {
+			t.Errorf("%+v.resolve(%d) = %d, %d, %v, want %d, %d, %v", tt.br, tt.size, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestValidatePartialContent(t *testing.T) {
+	tests := []struct {
+		name          string
+		rangeHeader   string
+		contentRange  string
+		contentType   string
+		contentLength int64
+		ok            bool
+	}{
+		{"match", "bytes=0-4", "bytes 0-4/10", "", 5, true},
+		{"truncated", "bytes=0-99", "bytes 0-9/10", "", 10, true},
+		{"open ended", "bytes=5-", "bytes 5-9/10", "", -1, true},
+		{"suffix", "bytes=-3", "bytes 7-9/10", "", 3, true},
+		{"unknown size", "bytes=5-", "bytes 5-9/*", "", 5, true},
+		{"non-range request", "", "bytes 0-4/10", "", 5, false},
+		{"mismatched start", "bytes=0-4", "bytes 1-4/10", "", 4, false},
+		{"beyond requested end", "bytes=0-4", "bytes 0-9/10", "", 10, false},
+		{"mismatched suffix", "bytes=-3", "bytes 0-2/10", "", 3, false},
+		{"mismatched length", "bytes=0-4", "bytes 0-4/10", "", 10, false},
+		{"missing content range", "bytes=0-4", "", "", 5, false},
+		{"invalid content range", "bytes=0-4", "bytes 4-0/10", "", 5, false},
+		{"multi-range multipart", "bytes=0-1,5-6", "", "multipart/byteranges; boundary=x", -1, true},
+		{"multi-range single part", "bytes=0-1,5-6", "bytes 5-6/10", "", 2, true},
+		{"multi-range mismatched", "bytes=0-1,5-6", "bytes 3-4/10", "", 2, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.rangeHeader != "" {
+			r.Header.Set("Range", tt.rangeHeader)
+		}
+		response := &http.Response{StatusCode: http.StatusPartialContent, Header: http.Header{}, ContentLength: tt.contentLength}
+		if tt.contentRange != "" {
+			response.Header.Set("Content-Range", tt.contentRange)
+		}
+		if tt.contentType != "" {
+			response.Header.Set("Content-Type", tt.contentType)
+		}
+		if err := validatePartialContent(r, response); (err == nil) != tt.ok {
+			t.Errorf("%s: validatePartialContent error = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestSliceRange(t *testing.T) {
+	const body = "0123456789"
+	tests := []struct {
+		name       string
+		header     http.Header
+		respHeader http.Header
+		status     int
+		content    string
+		written    int64
+	}{
+		{"no range", nil, nil, http.StatusOK, body, 10},
+		{"single range", http.Header{"Range": {"bytes=2-4"}}, nil, http.StatusPartialContent, "234", 3},
+		{"suffix range", http.Header{"Range": {"bytes=-3"}}, nil, http.StatusPartialContent, "789", 3},
+		{"open ended", http.Header{"Range": {"bytes=8-"}}, nil, http.StatusPartialContent, "89", 2},
+		{"end past eof", http.Header{"Range": {"bytes=8-100"}}, nil, http.StatusPartialContent, "89", 2},
+		{"start past eof", http.Header{"Range": {"bytes=10-"}}, nil, http.StatusRequestedRangeNotSatisfiable, "", -1},
+		{"multi-range", http.Header{"Range": {"bytes=0-1,5-6"}}, nil, http.StatusOK, body, 10},
+		{"invalid range", http.Header{"Range": {"bytes=x"}}, nil, http.StatusOK, body, 10},
+		{"if-range etag match", http.Header{"Range": {"bytes=0-1"}, "If-Range": {`"v1"`}},
+			http.Header{"Etag": {`"v1"`}}, http.StatusPartialContent, "01", 2},
+		{"if-range etag mismatch", http.Header{"Range": {"bytes=0-1"}, "If-Range": {`"v2"`}},
+			http.Header{"Etag": {`"v1"`}}, http.StatusOK, body, 10},
+		{"if-range weak etag", http.Header{"Range": {"bytes=0-1"}, "If-Range": {`W/"v1"`}},
+			http.Header{"Etag": {`W/"v1"`}}, http.StatusOK, body, 10},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range tt.header {
+			r.Header[k] = v
+		}
+		response := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, ContentLength: int64(len(body)),
+			Body: io.NopCloser(strings.NewReader(body))}
+		for k, v := range tt.respHeader {
+			response.Header[k] = v
+		}
+
+		rec := httptest.NewRecorder()
+		var written int64
+		var err error
+		if br, ok := sliceRange(r, response); ok {
+			written, err = writeSlicedRange(rec, response, br)
+		} else {
+			rec.WriteHeader(response.StatusCode)
+			written, err = io.Copy(rec, response.Body)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if rec.Code != tt.status || rec.Body.String() != tt.content || written != tt.written {
+			t.Errorf("%s: got %d %q written %d, want %d %q written %d",
+				tt.name, rec.Code, rec.Body.String(), written, tt.status, tt.content, tt.written)
+		}
+		if rec.Code == http.StatusRequestedRangeNotSatisfiable {
+			if got := rec.Header().Get("Content-Range"); got != "bytes */10" {
+				t.Errorf("%s: Content-Range = %q, want bytes */10", tt.name, got)
+			}
+		}
+	}
+}
+
+// 上游返回的 206 与请求的范围不一致时返回 502，忽略 Range 时由本地截取
+func TestDownloadUrlRanges(t *testing.T) {
+	const body = "0123456789"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/wrong" {
+			w.Header().Set("Content-Range", "bytes 0-9/10")
+			w.Header().Set("Content-Length", "10")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = io.WriteString(w, body)
+			return
+		}
+		// 忽略 Range 请求头
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = io.WriteString(w, body)
+	}))
+	defer upstream.Close()
+	dh := NewDownloadHandler(storage.NewMemory(), "")
+
+	tests := []struct {
+		path, rangeHeader string
+		status            int
+		content           string
+	}{
+		{"/wrong", "bytes=2-4", http.StatusBadGateway, ""},
+		{"/ignore", "bytes=2-4", http.StatusPartialContent, "234"},
+		{"/ignore", "bytes=-2", http.StatusPartialContent, "89"},
+		{"/ignore", "bytes=10-", http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/download?url="+url.QueryEscape(upstream.URL+tt.path), nil)
+		r.Header.Set("Range", tt.rangeHeader)
+		rec := httptest.NewRecorder()
+		dh.ServeHTTP(rec, r)
+		if rec.Code != tt.status || (tt.content != "" && rec.Body.String() != tt.content) {
+			t.Errorf("%s %s: got %d %q, want %d %q", tt.path, tt.rangeHeader, rec.Code, rec.Body.String(), tt.status, tt.content)
+		}
+	}
+}
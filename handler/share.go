@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/junlongzzz/file-download-agent/links"
+	"github.com/junlongzzz/file-download-agent/storage"
+	"github.com/junlongzzz/file-download-agent/trash"
+	"github.com/junlongzzz/file-download-agent/versions"
+)
+
+// WebDAV 文件分享，链接保存在 links 中，通过 download 生成下载地址
+type webDavShare struct {
+	download *DownloadHandler
+	links    *links.Store
+}
+
+// 分享文件的请求体
+type shareRequest struct {
+	TTL      string `json:"ttl"`      // 有效时长，如 24h，为空时不过期
+	MaxUses  int64  `json:"max_uses"` // 最大下载次数，0 表示不限制
+	Filename string `json:"filename"` // 下载时的文件名，为空时使用原文件名
+}
+
+// SetShare 设置文件分享，store 为空时关闭分享
+// 分享的链接使用 download 的签名key加密，download 需要通过 SetWebDavStorage 设置 WebDAV 根目录
+func (wh *WebDavHandler) SetShare(download *DownloadHandler, store *links.Store) {
+	if download == nil || store == nil {
+		wh.share.Store(nil)
+		return
+	}
+	wh.share.Store(&webDavShare{download: download, links: store})
+}
+
+// 是否可以分享文件，需要启用分享并设置签名key
+func (wh *WebDavHandler) canShare() bool {
+	share := wh.share.Load()
+	return share != nil && share.download.settings.Load().signKey != ""
+}
+
+// 为文件创建 /download 下载链接，POST /webdav/<文件>?share
+// 需要有 GET 权限，只能分享 WebDAV 根目录下的文件
+func (wh *WebDavHandler) serveShare(w http.ResponseWriter, r *http.Request, account *webDavAccount) {
+	share := wh.share.Load()
+	if share == nil {
+		http.Error(w, "Sharing is disabled", http.StatusForbidden)
+		return
+	}
+	if !wh.canShare() {
+		// 未设置签名key时 enc 可以被任何人解密并伪造
+		http.Error(w, "Sharing requires a sign key", http.StatusForbidden)
+		return
+	}
+	if !account.allow(http.MethodGet, false) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, account.handler.Prefix))
+	if versions.IsVersionsPath(name) || trash.IsTrashPath(name) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	info, err := account.handler.FileSystem.Stat(r.Context(), name)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		http.Error(w, "Only files can be shared", http.StatusBadRequest)
+		return
+	}
+	// 下载链接中的路径相对于 WebDAV 根目录
+	rel, ok := storage.Rel(wh.storage, account.storage)
+	if !ok {
+		http.Error(w, "Sharing is not available for directories outside the WebDAV dir", http.StatusForbidden)
+		return
+	}
+
+	var body shareRequest
+	if err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodySize)).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.MaxUses < 0 {
+		http.Error(w, "Invalid max_uses: must not be negative", http.StatusBadRequest)
+		return
+	}
+	var expire int64
+	if body.TTL != "" {
+		ttl, err := time.ParseDuration(body.TTL)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "Invalid ttl: must be a positive duration, e.g. 24h", http.StatusBadRequest)
+			return
+		}
+		expire = time.Now().Add(ttl).Unix()
+	}
+
+	username, _, _ := r.BasicAuth()
+	link, err := share.links.Create(links.Link{
+		Url:      (&url.URL{Scheme: "webdav", Path: path.Join(rel, name)}).String(),
+		Filename: body.Filename,
+		Expire:   expire,
+		MaxUses:  body.MaxUses,
+		Note:     "WebDAV share",
+		Creator:  username,
+	})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Create link error: %v", err))
+		http.Error(w, "Failed to create link", http.StatusInternalServerError)
+		return
+	}
+	result, err := newAdminLink(r, share.download, link)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Encrypt link error: %v", err))
+		http.Error(w, "Failed to encrypt data", http.StatusInternalServerError)
+		return
+	}
+	slog.Info(fmt.Sprintf("Link created: %s -> %s", link.ID, link.Url))
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, result)
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/junlongzzz/file-download-agent/trash"
+)
+
+// TrashHandler 查看、恢复与永久删除所有 WebDAV 用户回收站中的项目
+type TrashHandler struct {
+	mux   *http.ServeMux
+	trash *trash.Manager
+}
+
+// NewTrashHandler 创建Handler
+func NewTrashHandler(manager *trash.Manager) *TrashHandler {
+	th := &TrashHandler{mux: http.NewServeMux(), trash: manager}
+	th.mux.HandleFunc("GET /admin/webdav/trash", th.list)
+	th.mux.HandleFunc("POST /admin/webdav/trash/{id}", th.restore)
+	th.mux.HandleFunc("DELETE /admin/webdav/trash/{id}", th.delete)
+	return th
+}
+
+func (th *TrashHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	th.mux.ServeHTTP(w, r)
+}
+
+// 支持参数 user 按删除的用户过滤
+func (th *TrashHandler) list(w http.ResponseWriter, r *http.Request) {
+	entries, err := th.trash.List(r.Context())
+	if err != nil {
+		writeTrashError(w, err)
+		return
+	}
+	if user := r.URL.Query().Get("user"); user != "" {
+		filtered := entries[:0]
+		for _, entry := range entries {
+			if entry.User == user {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": entries})
+}
+
+func (th *TrashHandler) restore(w http.ResponseWriter, r *http.Request) {
+	entry, err := th.trash.Restore(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeTrashError(w, err)
+		return
+	}
+	slog.Info(fmt.Sprintf("Trash item restored: %s -> %s", entry.ID, entry.Path))
+	writeJSON(w, http.StatusOK, entry)
+}
+
+func (th *TrashHandler) delete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := th.trash.Delete(r.Context(), id); err != nil {
+		writeTrashError(w, err)
+		return
+	}
+	slog.Info(fmt.Sprintf("Trash item deleted: %s", id))
+	w.WriteHeader(http.StatusNoContent)
+}
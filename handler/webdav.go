@@ -1,45 +1,699 @@
 package handler
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/junlongzzz/file-download-agent/audit"
+	"github.com/junlongzzz/file-download-agent/common"
+	"github.com/junlongzzz/file-download-agent/metrics"
+	"github.com/junlongzzz/file-download-agent/passwd"
+	"github.com/junlongzzz/file-download-agent/quota"
+	"github.com/junlongzzz/file-download-agent/storage"
+	"github.com/junlongzzz/file-download-agent/trash"
+	"github.com/junlongzzz/file-download-agent/versions"
 	"golang.org/x/net/webdav"
 )
 
 type WebDavHandler struct {
-	// webdav handler
-	handler *webdav.Handler
-	// basic 用户名 密码
-	username, password string
-}
-
-// NewWebDavHandler 创建Handler
-func NewWebDavHandler(dir, username, password string) *WebDavHandler {
-	return &WebDavHandler{
-		handler: &webdav.Handler{
-			Prefix:     "/webdav",
-			FileSystem: webdav.Dir(dir),
-			LockSystem: webdav.NewMemLS(),
+	// webdav 根目录
+	storage storage.Storage
+	// 所有用户共用的锁，按实际路径加锁，共享目录的用户之间锁互相可见
+	locks webdav.LockSystem
+	// 用户与各自的 webdav handler，可热更新
+	accounts atomic.Pointer[webDavAccounts]
+	// 认证成功的缓存时长，避免每个请求都计算密码哈希
+	authCacheTTL atomic.Int64
+	// 全局只读，所有用户都不能修改文件
+	readOnly atomic.Bool
+	// 审计日志，记录修改文件的操作
+	auditLog atomic.Pointer[audit.Store]
+	// 根目录与用户目录的空间占用
+	usage *quota.Tracker
+	// 文件分享，为空时不能分享
+	share atomic.Pointer[webDavShare]
+}
+
+// WebDavUser WebDAV 用户
+type WebDavUser struct {
+	Username string
+	Password string   // 明文或 bcrypt、argon2id、htpasswd 格式的哈希
+	Dir      string   // 用户根目录，相对路径时位于 WebDAV 根目录下，为空时为 WebDAV 根目录，本地存储支持绝对路径
+	ReadOnly bool     // 只读用户不能修改文件
+	Methods  []string // 允许使用的方法，为空时不限制，不含修改文件的方法时视为只读
+	Quota    int64    // 用户目录最多使用的字节数，0 表示不限制
+}
+
+// 用户的认证信息、权限与独立的 webdav handler
+type webDavAccount struct {
+	password string
+	readOnly bool
+	methods  map[string]bool // 允许使用的方法，为空时不限制
+	root     string          // 根目录的标识
+	storage  storage.Storage // 根目录
+	handler  *webdav.Handler
+	quota    *quotaFileSystem // 未设置配额时为空
+	trash    *trash.Bin       // 未启用回收站时为空
+	versions *versions.Store  // 未启用历史版本时为空
+}
+
+// 判断是否允许使用该方法，readOnly 为全局只读开关
+func (a *webDavAccount) allow(method string, readOnly bool) bool {
+	if method == http.MethodOptions {
+		// 客户端连接时需要获取支持的方法
+		return true
+	}
+	if (readOnly || a.readOnly) && isWebDavWriteMethod(method) {
+		return false
+	}
+	return len(a.methods) == 0 || a.methods[method]
+}
+
+// 所有用户，未配置用户时不认证，所有请求使用 public
+// 替换用户时认证缓存随之丢弃
+type webDavAccounts struct {
+	users  map[string]*webDavAccount
+	public *webDavAccount
+	cache  *authCache
+	opts   webDavAccountOptions       // 创建用户时使用的配置
+	bins   map[string]*trash.Bin      // 根目录 -> 回收站，未启用回收站时为空
+	stores map[string]*versions.Store // 根目录 -> 历史版本
+	// 用户不存在时用于校验的哈希，使响应时间与用户存在时相同，避免枚举用户名
+	// 使用第一个用户的密码哈希，算法与计算成本和配置的哈希一致
+	dummy string
+}
+
+// 创建用户时使用的配额、回收站与历史版本配置
+type webDavAccountOptions struct {
+	quota         int64          // 根目录总配额，0 表示不限制
+	trash         *trash.Manager // 为空时直接删除文件
+	versionKeep   int            // 覆盖文件时保留的版本数
+	versionMaxAge time.Duration  // 历史版本的保留时长
+}
+
+// WebDavOptions 可热更新的 WebDAV 配置
+type WebDavOptions struct {
+	Users         []WebDavUser   // 为空时不认证
+	AuthCacheTTL  time.Duration  // 认证成功的缓存时长，0 表示不缓存
+	ReadOnly      bool           // 全局只读
+	Quota         int64          // 根目录总配额，0 表示不限制，用户目录位于根目录之外时不受限制
+	Trash         *trash.Manager // 回收站，为空时直接删除文件
+	Versions      int            // 覆盖文件时保留的版本数
+	VersionMaxAge time.Duration  // 历史版本的保留时长，Versions 与 VersionMaxAge 都为 0 时不保留
+}
+
+// 返回所有用户
+func (a *webDavAccounts) all() []*webDavAccount {
+	if a.public != nil {
+		return []*webDavAccount{a.public}
+	}
+	return slices.Collect(maps.Values(a.users))
+}
+
+// 认证缓存最大条目数，超过时清空
+const maxAuthCacheEntries = 1024
+
+// 认证成功的缓存，以用户名与密码的 HMAC 为键，不保存密码
+type authCache struct {
+	key     []byte
+	mu      sync.Mutex
+	entries map[string]time.Time // 过期时间
+}
+
+func newAuthCache() *authCache {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return &authCache{key: key, entries: make(map[string]time.Time)}
+}
+
+func (c *authCache) sum(username, password string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return string(mac.Sum(nil))
+}
+
+// 校验用户密码，成功时缓存 ttl 时长
+func (c *authCache) verify(account *webDavAccount, username, password string, ttl time.Duration) bool {
+	if ttl <= 0 {
+		return passwd.Verify(account.password, password)
+	}
+	key := c.sum(username, password)
+	now := time.Now()
+	c.mu.Lock()
+	expire, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(expire) {
+		return true
+	}
+	if !passwd.Verify(account.password, password) {
+		return false
+	}
+	c.mu.Lock()
+	if len(c.entries) >= maxAuthCacheEntries {
+		clear(c.entries)
+	}
+	c.entries[key] = now.Add(ttl)
+	c.mu.Unlock()
+	return true
+}
+
+// 修改文件的 WebDAV 方法
+var webDavWriteMethods = map[string]bool{
+	http.MethodPut:    true,
+	http.MethodDelete: true,
+	"MKCOL":           true,
+	"MOVE":            true,
+	"COPY":            true,
+	"PROPPATCH":       true,
+}
+
+// 是否为只读时拒绝的方法，LOCK 可能创建空文件
+func isWebDavWriteMethod(method string) bool {
+	return webDavWriteMethods[method] || method == "LOCK"
+}
+
+// NewWebDavHandler 创建Handler，root 为 WebDAV 根目录，locks 为空时使用内存中的锁
+func NewWebDavHandler(root storage.Storage, locks webdav.LockSystem, username, password string) (*WebDavHandler, error) {
+	if locks == nil {
+		locks = webdav.NewMemLS()
+	}
+	wh := &WebDavHandler{storage: root, locks: locks, usage: quota.NewTracker()}
+	if err := wh.SetBasicAuth(username, password); err != nil {
+		return nil, err
+	}
+	return wh, nil
+}
+
+// SetAuditLog 设置审计日志
+func (wh *WebDavHandler) SetAuditLog(store *audit.Store) {
+	wh.auditLog.Store(store)
+}
+
+// SetAuthCacheTTL 设置认证成功的缓存时长，0 表示不缓存
+func (wh *WebDavHandler) SetAuthCacheTTL(ttl time.Duration) {
+	wh.authCacheTTL.Store(int64(ttl))
+}
+
+// SetReadOnly 设置全局只读，开启后所有用户都不能修改文件
+func (wh *WebDavHandler) SetReadOnly(readOnly bool) {
+	wh.readOnly.Store(readOnly)
+}
+
+// SetBasicAuth 设置单个用户的basic认证信息，用户可读写整个根目录
+// 用户名或密码为空时不认证
+func (wh *WebDavHandler) SetBasicAuth(username, password string) error {
+	if username == "" || password == "" {
+		return wh.SetUsers(nil)
+	}
+	return wh.SetUsers([]WebDavUser{{Username: username, Password: password}})
+}
+
+// SetUsers 替换全部用户，配额、回收站与历史版本配置不变，用户根目录不存在时创建，users 为空时不认证
+// 任一用户根目录创建失败时保持原用户不变
+func (wh *WebDavHandler) SetUsers(users []WebDavUser) error {
+	var opts webDavAccountOptions
+	if current := wh.accounts.Load(); current != nil {
+		opts = current.opts
+	}
+	accounts, err := wh.newAccounts(users, opts)
+	if err != nil {
+		return err
+	}
+	wh.storeAccounts(accounts)
+	return nil
+}
+
+// Prepare 创建新配置下的所有用户，返回应用配置的函数
+// 任一用户创建失败时返回错误，当前配置保持不变
+func (wh *WebDavHandler) Prepare(opts WebDavOptions) (func(), error) {
+	accounts, err := wh.newAccounts(opts.Users, webDavAccountOptions{
+		quota:         opts.Quota,
+		trash:         opts.Trash,
+		versionKeep:   opts.Versions,
+		versionMaxAge: opts.VersionMaxAge,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		wh.authCacheTTL.Store(int64(opts.AuthCacheTTL))
+		wh.readOnly.Store(opts.ReadOnly)
+		wh.storeAccounts(accounts)
+	}, nil
+}
+
+// 创建全部用户
+func (wh *WebDavHandler) newAccounts(users []WebDavUser, opts webDavAccountOptions) (*webDavAccounts, error) {
+	accounts := &webDavAccounts{users: make(map[string]*webDavAccount, len(users)), cache: newAuthCache(), opts: opts,
+		stores: make(map[string]*versions.Store)}
+	if opts.trash != nil {
+		accounts.bins = make(map[string]*trash.Bin)
+	}
+	if len(users) == 0 {
+		account, err := wh.newAccount(WebDavUser{}, accounts)
+		if err != nil {
+			return nil, err
+		}
+		accounts.public = account
+	}
+	for _, user := range users {
+		account, err := wh.newAccount(user, accounts)
+		if err != nil {
+			return nil, fmt.Errorf("webdav user %s: %w", user.Username, err)
+		}
+		accounts.users[user.Username] = account
+	}
+	if len(users) > 0 {
+		accounts.dummy = users[0].Password
+	}
+	return accounts, nil
+}
+
+// 替换全部用户，并释放不再使用的目录统计与回收站
+func (wh *WebDavHandler) storeAccounts(accounts *webDavAccounts) {
+	old := wh.accounts.Swap(accounts)
+	// 不再统计已删除用户的目录
+	var roots []string
+	for _, account := range accounts.all() {
+		if account.quota != nil {
+			for _, limit := range account.quota.limits {
+				roots = append(roots, limit.Usage.Root())
+			}
+			roots = append(roots, account.quota.own.Root())
+		}
+	}
+	wh.usage.Retain(roots)
+	if old != nil && old.opts.trash != nil && old.opts.trash != accounts.opts.trash {
+		old.opts.trash.SetBins(nil)
+	}
+	if accounts.opts.trash != nil {
+		accounts.opts.trash.SetBins(accounts.bins)
+	}
+}
+
+// 创建用户的 webdav handler，只能访问自己的根目录
+func (wh *WebDavHandler) newAccount(user WebDavUser, accounts *webDavAccounts) (*webDavAccount, error) {
+	files, err := wh.storage.Sub(context.Background(), user.Dir)
+	if err != nil {
+		return nil, err
+	}
+	root := files.Root()
+	account := &webDavAccount{password: user.Password, readOnly: user.ReadOnly, root: root, storage: files}
+	if len(user.Methods) > 0 {
+		account.methods = make(map[string]bool, len(user.Methods))
+		writable := false
+		for _, method := range user.Methods {
+			method = strings.ToUpper(method)
+			account.methods[method] = true
+			writable = writable || isWebDavWriteMethod(method)
+		}
+		// 不允许任何修改文件的方法时，文件系统也按只读处理
+		account.readOnly = account.readOnly || !writable
+	}
+	var fs webdav.FileSystem = files
+	if account.quota, err = wh.newQuotaFileSystem(files, user.Quota, accounts.opts.quota); err != nil {
+		return nil, err
+	} else if account.quota != nil {
+		fs = account.quota
+	}
+	// 版本直接保存在配额层，删除旧版本时不移入回收站
+	base := fs
+	if accounts.bins != nil {
+		// 共用根目录的用户共用回收站
+		if account.trash = accounts.bins[root]; account.trash == nil {
+			account.trash = trash.NewBin(fs)
+			accounts.bins[root] = account.trash
+		}
+		fs = &trashFileSystem{FileSystem: fs, bin: account.trash, user: user.Username}
+	}
+	if keep, maxAge := accounts.opts.versionKeep, accounts.opts.versionMaxAge; keep > 0 || maxAge > 0 {
+		// 共用根目录的用户共用版本号的分配
+		if account.versions = accounts.stores[root]; account.versions == nil {
+			account.versions = versions.NewStore(base, keep, maxAge)
+			accounts.stores[root] = account.versions
+		}
+		fs = &versionFileSystem{FileSystem: fs, base: base, store: account.versions}
+	}
+	account.handler = &webdav.Handler{
+		Prefix: "/webdav",
+		// 除了按方法拒绝外，在文件系统层面拒绝写入，避免遗漏的方法修改文件
+		FileSystem: &readOnlyFileSystem{
+			FileSystem: fs,
+			readOnly: func() bool {
+				return account.readOnly || wh.readOnly.Load()
+			},
 		},
-		username: username,
-		password: password,
+		LockSystem: &rootedLockSystem{LockSystem: wh.locks, root: path.Join("/", root)},
 	}
+	return account, nil
 }
 
-// SetBasicAuth 设置basic认证信息
-func (wh *WebDavHandler) SetBasicAuth(username, password string) {
-	wh.username = username
-	wh.password = password
+// 用户或全局设置了配额时返回限制空间占用的文件系统，否则返回空
+// 首次使用的目录需要扫描统计占用
+func (wh *WebDavHandler) newQuotaFileSystem(files storage.Storage, userQuota, total int64) (*quotaFileSystem, error) {
+	ctx := context.Background()
+	var limits []quota.Limit
+	if total > 0 {
+		if _, ok := storage.Rel(wh.storage, files); ok {
+			usage, err := wh.usage.Usage(ctx, wh.storage.Root(), wh.storage)
+			if err != nil {
+				return nil, err
+			}
+			limits = append(limits, quota.Limit{Usage: usage, Bytes: total})
+		}
+	}
+	if userQuota <= 0 && len(limits) == 0 {
+		return nil, nil
+	}
+	own, err := wh.usage.Usage(ctx, files.Root(), files)
+	if err != nil {
+		return nil, err
+	}
+	if userQuota > 0 {
+		limits = append(limits, quota.Limit{Usage: own, Bytes: userQuota})
+	}
+	return &quotaFileSystem{FileSystem: files, root: files.Root(), tracker: wh.usage, own: own, limits: limits}, nil
 }
 
 func (wh *WebDavHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if wh.username != "" && wh.password != "" {
+	rec := common.NewResponseRecorder(w)
+	// 统计上传的字节数
+	body := &countingReader{ReadCloser: r.Body}
+	r.Body = body
+	defer func() {
+		metrics.WebDavOperations.WithLabelValues(metrics.Method(r.Method), strconv.Itoa(rec.Status())).Inc()
+		metrics.BytesSent.WithLabelValues(metrics.SourceWebDav).Add(float64(rec.Written()))
+		if webDavWriteMethods[r.Method] {
+			wh.audit(r, rec, body.n)
+		}
+	}()
+	w = rec
+
+	accounts := wh.accounts.Load()
+	account := accounts.public
+	if account == nil {
 		username, password, ok := r.BasicAuth()
-		if !ok || username != wh.username || password != wh.password {
+		account = accounts.users[username]
+		if ok && account == nil {
+			_ = passwd.Verify(accounts.dummy, password)
+		}
+		if !ok || account == nil || !accounts.cache.verify(account, username, password, time.Duration(wh.authCacheTTL.Load())) {
 			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 	}
-	wh.handler.ServeHTTP(w, r)
+	if account.trash != nil && trash.IsTrashPath(strings.TrimPrefix(r.URL.Path, account.handler.Prefix)) {
+		wh.serveTrash(w, r, account)
+		return
+	}
+	if r.Method == http.MethodPost && r.URL.Query().Has("share") {
+		wh.serveShare(w, r, account)
+		return
+	}
+	if !account.allow(r.Method, wh.readOnly.Load()) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if query := r.URL.Query(); account.versions != nil && (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		(query.Has("versions") || query.Has("version")) {
+		wh.serveVersions(w, r, account)
+		return
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		// webdav.Handler 不支持 GET 目录，返回目录列表
+		name := strings.TrimPrefix(r.URL.Path, account.handler.Prefix)
+		if info, err := account.handler.FileSystem.Stat(r.Context(), name); err == nil && info.IsDir() {
+			if !strings.HasSuffix(r.URL.Path, "/") {
+				// 以 / 结尾，页面中的相对链接才能正确解析
+				target := (&url.URL{Path: r.URL.Path + "/", RawQuery: r.URL.RawQuery}).String()
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+				return
+			}
+			wh.serveListing(w, r, account, name)
+			return
+		}
+	}
+	r = r.WithContext(context.WithValue(r.Context(), webDavMethodKey{}, r.Method))
+	if account.quota != nil {
+		wh.serveWithQuota(w, r, account)
+		return
+	}
+	account.handler.ServeHTTP(w, r)
+}
+
+// 超出配额时返回 507，上传或复制失败的文件会被删除
+func (wh *WebDavHandler) serveWithQuota(w http.ResponseWriter, r *http.Request, account *webDavAccount) {
+	name := strings.TrimPrefix(r.URL.Path, account.handler.Prefix)
+	if r.Method == "COPY" {
+		// 复制时写入的是目标路径
+		name = ""
+		if destUrl, err := url.Parse(r.Header.Get("Destination")); err == nil {
+			name = strings.TrimPrefix(destUrl.Path, account.handler.Prefix)
+		}
+	}
+	if r.Method == http.MethodPut && r.ContentLength > 0 {
+		// 根据请求体大小提前拒绝，客户端无需上传
+		if available := account.quota.available(); available >= 0 {
+			// 启用回收站时覆盖的文件仍占用空间
+			if info, err := account.quota.Stat(r.Context(), name); err == nil && info.Mode().IsRegular() &&
+				account.trash == nil && account.versions == nil {
+				available += info.Size()
+			}
+			if r.ContentLength > available {
+				http.Error(w, "Insufficient Storage", http.StatusInsufficientStorage)
+				return
+			}
+		}
+	}
+	ctx, exceeded := withQuotaFlag(r.Context())
+	r = r.WithContext(ctx)
+	account.handler.ServeHTTP(&quotaResponseWriter{ResponseWriter: w, exceeded: exceeded}, r)
+	if exceeded.Load() && (r.Method == http.MethodPut || r.Method == "COPY") && name != "" {
+		_ = account.quota.RemoveAll(context.WithoutCancel(ctx), name)
+	}
+}
+
+// 用户的回收站
+// GET /webdav/.trash 列出项目，POST /webdav/.trash/{id} 恢复，DELETE /webdav/.trash/{id} 永久删除
+func (wh *WebDavHandler) serveTrash(w http.ResponseWriter, r *http.Request, account *webDavAccount) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, account.handler.Prefix+"/"+trash.Dir), "/")
+	// 恢复与删除分别按 MOVE 与 DELETE 检查权限
+	method := r.Method
+	switch {
+	case id == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+	case id != "" && r.Method == http.MethodPost:
+		method = "MOVE"
+	case id != "" && r.Method == http.MethodDelete:
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !account.allow(method, wh.readOnly.Load()) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := r.Context()
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		var item *trash.Item
+		if item, err = account.trash.Restore(ctx, id); err == nil {
+			writeJSON(w, http.StatusOK, item)
+			return
+		}
+	case http.MethodDelete:
+		if err = account.trash.Delete(ctx, id); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		var items []trash.Item
+		if items, err = account.trash.List(ctx); err == nil {
+			writeJSON(w, http.StatusOK, map[string]any{"items": items})
+			return
+		}
+	}
+	writeTrashError(w, err)
+}
+
+// 文件的历史版本，GET ?versions 列出版本，GET ?version=N 下载指定版本
+func (wh *WebDavHandler) serveVersions(w http.ResponseWriter, r *http.Request, account *webDavAccount) {
+	name := strings.TrimPrefix(r.URL.Path, account.handler.Prefix)
+	if versions.IsVersionsPath(name) || trash.IsTrashPath(name) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	if !query.Has("version") {
+		list, err := account.versions.List(r.Context(), name)
+		if err != nil {
+			slog.Error(fmt.Sprintf("List WebDAV versions error: %v", err))
+			http.Error(w, "Failed to list versions", http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []versions.Version{}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, map[string]any{"path": path.Clean("/" + name), "versions": list})
+		return
+	}
+	number, err := strconv.Atoi(query.Get("version"))
+	if err != nil || number <= 0 {
+		http.Error(w, "Invalid version: must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	f, version, err := account.versions.Open(r.Context(), name, number)
+	if errors.Is(err, versions.ErrNotFound) {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error(fmt.Sprintf("Open WebDAV version error: %v", err))
+		http.Error(w, "Failed to open version", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	http.ServeContent(w, r, path.Base(name), version.ModTime, f)
+}
+
+// 回收站操作失败的响应
+func writeTrashError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, trash.ErrNotFound):
+		http.Error(w, "Trash item not found", http.StatusNotFound)
+	case errors.Is(err, trash.ErrExists):
+		http.Error(w, "Original path already exists", http.StatusConflict)
+	case errors.Is(err, quota.ErrExceeded):
+		http.Error(w, "Insufficient Storage", http.StatusInsufficientStorage)
+	default:
+		slog.Error(fmt.Sprintf("WebDAV trash error: %v", err))
+		http.Error(w, "Trash operation failed", http.StatusInternalServerError)
+	}
+}
+
+// 记录修改文件的操作
+func (wh *WebDavHandler) audit(r *http.Request, rec *common.ResponseRecorder, uploaded int64) {
+	store := wh.auditLog.Load()
+	if store == nil {
+		return
+	}
+	user, _, _ := r.BasicAuth()
+	record := audit.Record{
+		Kind:      audit.KindWebDav,
+		Method:    r.Method,
+		Target:    r.URL.Path,
+		User:      user,
+		ClientIP:  common.GetRealIP(r),
+		UserAgent: r.UserAgent(),
+		Status:    rec.Status(),
+		Bytes:     uploaded,
+	}
+	if dest := r.Header.Get("Destination"); dest != "" {
+		if destUrl, err := url.Parse(dest); err == nil {
+			record.Dest = destUrl.Path
+		}
+	}
+	store.Append(record)
+}
+
+// 统计已读取字节数的请求体
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// 将用户可见的路径转换为实际路径后加锁，使不同根目录的用户共用同一个 LockSystem
+type rootedLockSystem struct {
+	webdav.LockSystem
+	root string // 用户根目录的实际路径，以 / 开头
+}
+
+func (ls *rootedLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	return ls.LockSystem.Confirm(now, ls.join(name0), ls.join(name1), conditions...)
+}
+
+func (ls *rootedLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	details.Root = ls.join(details.Root)
+	return ls.LockSystem.Create(now, details)
+}
+
+func (ls *rootedLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	if err := ls.check(now, token); err != nil {
+		return webdav.LockDetails{}, err
+	}
+	details, err := ls.LockSystem.Refresh(now, token, duration)
+	if err != nil {
+		return details, err
+	}
+	if ls.root != "/" {
+		details.Root = "/" + strings.TrimPrefix(strings.TrimPrefix(details.Root, ls.root), "/")
+	}
+	return details, nil
+}
+
+func (ls *rootedLockSystem) Unlock(now time.Time, token string) error {
+	if err := ls.check(now, token); err != nil {
+		return err
+	}
+	return ls.LockSystem.Unlock(now, token)
+}
+
+// 可以按 token 查询锁定路径的锁系统
+type lockRooter interface {
+	Root(now time.Time, token string) (string, error)
+}
+
+// 在修改锁之前检查锁是否位于用户根目录下，不能刷新或解除其他用户的锁
+func (ls *rootedLockSystem) check(now time.Time, token string) error {
+	if ls.root == "/" {
+		return nil
+	}
+	rooter, ok := ls.LockSystem.(lockRooter)
+	if !ok {
+		// 无法确认锁定的路径
+		return webdav.ErrForbidden
+	}
+	root, err := rooter.Root(now, token)
+	if err != nil {
+		return err
+	}
+	if root != ls.root && !strings.HasPrefix(root, ls.root+"/") {
+		// 其他用户根目录之外的锁
+		return webdav.ErrForbidden
+	}
+	return nil
+}
+
+// 空路径表示未使用，保持为空
+func (ls *rootedLockSystem) join(name string) string {
+	if name == "" {
+		return ""
+	}
+	return path.Join(ls.root, name)
 }
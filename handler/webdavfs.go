@@ -0,0 +1,424 @@
+package handler
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/junlongzzz/file-download-agent/quota"
+	"github.com/junlongzzz/file-download-agent/trash"
+	"github.com/junlongzzz/file-download-agent/versions"
+	"golang.org/x/net/webdav"
+)
+
+// 打开文件时表示写入的标志
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+// 只读文件系统，拒绝所有修改操作
+// readOnly 在每次操作时判断，用于支持热更新的全局只读开关
+type readOnlyFileSystem struct {
+	webdav.FileSystem
+	readOnly func() bool
+}
+
+func (fs *readOnlyFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if fs.readOnly() {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs *readOnlyFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&writeFlags != 0 && fs.readOnly() {
+		return nil, os.ErrPermission
+	}
+	return fs.FileSystem.OpenFile(ctx, name, flag, perm)
+}
+
+func (fs *readOnlyFileSystem) RemoveAll(ctx context.Context, name string) error {
+	if fs.readOnly() {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fs *readOnlyFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	if fs.readOnly() {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+// RFC 4331 配额属性
+var (
+	quotaAvailableBytes = xml.Name{Space: "DAV:", Local: "quota-available-bytes"}
+	quotaUsedBytes      = xml.Name{Space: "DAV:", Local: "quota-used-bytes"}
+)
+
+// 请求上下文中标记写入因超出配额失败，用于返回 507
+type quotaExceededKey struct{}
+
+func withQuotaFlag(ctx context.Context) (context.Context, *atomic.Bool) {
+	exceeded := &atomic.Bool{}
+	return context.WithValue(ctx, quotaExceededKey{}, exceeded), exceeded
+}
+
+// 不受配额限制的写入，只统计占用
+type quotaExemptKey struct{}
+
+func withoutQuota(ctx context.Context) context.Context {
+	return context.WithValue(ctx, quotaExemptKey{}, true)
+}
+
+func markQuotaExceeded(ctx context.Context) {
+	if exceeded, ok := ctx.Value(quotaExceededKey{}).(*atomic.Bool); ok {
+		exceeded.Store(true)
+	}
+}
+
+// 限制空间占用的文件系统，写入时增量统计占用，超出配额时写入失败
+type quotaFileSystem struct {
+	webdav.FileSystem
+	root    string // 存储根目录的标识
+	tracker *quota.Tracker
+	own     *quota.Usage  // 根目录的占用，用于 quota-used-bytes
+	limits  []quota.Limit // 用户与全局配额，都包含根目录
+}
+
+// 转换为配额统计使用的路径，即根目录的标识加路径
+func (fs *quotaFileSystem) real(name string) string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return fs.root
+	}
+	return strings.TrimSuffix(fs.root, "/") + name
+}
+
+// 剩余可用字节数，没有配额时返回 -1
+func (fs *quotaFileSystem) available() int64 {
+	return fs.tracker.Available(fs.limits...)
+}
+
+func (fs *quotaFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	var truncated int64
+	if flag&os.O_TRUNC != 0 {
+		if info, err := fs.FileSystem.Stat(ctx, name); err == nil && info.Mode().IsRegular() {
+			truncated = info.Size()
+		}
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	real := fs.real(name)
+	fs.tracker.Add(real, -truncated)
+	return &quotaFile{
+		File:   f,
+		fs:     fs,
+		ctx:    ctx,
+		name:   real,
+		dir:    info.IsDir(),
+		size:   info.Size(),
+		append: flag&os.O_APPEND != 0,
+	}, nil
+}
+
+func (fs *quotaFileSystem) RemoveAll(ctx context.Context, name string) error {
+	real := fs.real(name)
+	before, err := quota.Size(ctx, fs.FileSystem, name)
+	if err != nil {
+		return err
+	}
+	err = fs.FileSystem.RemoveAll(ctx, name)
+	after := int64(0)
+	if err != nil {
+		// 部分删除时重新统计剩余的大小
+		after, _ = quota.Size(ctx, fs.FileSystem, name)
+	}
+	fs.tracker.Add(real, after-before)
+	return err
+}
+
+func (fs *quotaFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	if err := fs.FileSystem.Rename(ctx, oldName, newName); err != nil {
+		return err
+	}
+	fs.tracker.Move(fs.real(oldName), fs.real(newName), func() int64 {
+		size, _ := quota.Size(ctx, fs.FileSystem, newName)
+		return size
+	})
+	return nil
+}
+
+// 统计写入增加的大小，目录返回配额属性
+type quotaFile struct {
+	webdav.File
+	fs     *quotaFileSystem
+	ctx    context.Context
+	name   string // 配额统计使用的路径
+	dir    bool
+	offset int64
+	size   int64
+	append bool
+}
+
+func (f *quotaFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *quotaFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.offset = pos
+	}
+	return pos, err
+}
+
+func (f *quotaFile) Write(p []byte) (int, error) {
+	if f.append {
+		f.offset = f.size
+	}
+	grow := f.offset + int64(len(p)) - f.size
+	if grow > 0 && f.ctx.Value(quotaExemptKey{}) != nil {
+		f.fs.tracker.Add(f.name, grow)
+	} else if grow > 0 {
+		if err := f.fs.tracker.Reserve(f.name, grow, f.fs.limits...); err != nil {
+			markQuotaExceeded(f.ctx)
+			return 0, err
+		}
+	}
+	n, err := f.File.Write(p)
+	f.offset += int64(n)
+	if grow > 0 {
+		// 释放未写入部分的占用
+		if unused := f.size + grow - max(f.offset, f.size); unused > 0 {
+			f.fs.tracker.Add(f.name, -unused)
+		}
+	}
+	f.size = max(f.size, f.offset)
+	return n, err
+}
+
+func (f *quotaFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	if !f.dir {
+		return nil, nil
+	}
+	used := strconv.FormatInt(f.fs.tracker.Used(f.fs.own), 10)
+	props := map[xml.Name]webdav.Property{
+		quotaUsedBytes: {XMLName: quotaUsedBytes, InnerXML: []byte(used)},
+	}
+	if available := f.fs.available(); available >= 0 {
+		props[quotaAvailableBytes] = webdav.Property{
+			XMLName:  quotaAvailableBytes,
+			InnerXML: []byte(strconv.FormatInt(available, 10)),
+		}
+	}
+	return props, nil
+}
+
+func (f *quotaFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return forbidPatches(patches), nil
+}
+
+// 不支持修改属性，与未实现 webdav.DeadPropsHolder 时一致
+func forbidPatches(patches []webdav.Proppatch) []webdav.Propstat {
+	pstat := webdav.Propstat{Status: http.StatusForbidden}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+		}
+	}
+	return []webdav.Propstat{pstat}
+}
+
+// 写入因超出配额失败时，将响应替换为 507 Insufficient Storage
+type quotaResponseWriter struct {
+	http.ResponseWriter
+	exceeded *atomic.Bool
+	rejected bool
+}
+
+func (w *quotaResponseWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest && w.exceeded.Load() {
+		w.rejected = true
+		http.Error(w.ResponseWriter, "Insufficient Storage", http.StatusInsufficientStorage)
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *quotaResponseWriter) Write(p []byte) (int, error) {
+	if w.rejected {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// 请求上下文中的请求方法，记录文件移入回收站的原因
+type webDavMethodKey struct{}
+
+// 将删除与覆盖的文件移入回收站的文件系统，回收站目录对客户端隐藏
+type trashFileSystem struct {
+	webdav.FileSystem
+	bin  *trash.Bin
+	user string
+}
+
+// 移入回收站，写入的项目信息不受配额限制，避免超出配额时无法删除
+func (fs *trashFileSystem) put(ctx context.Context, name string) error {
+	method, _ := ctx.Value(webDavMethodKey{}).(string)
+	_, err := fs.bin.Put(withoutQuota(ctx), name, fs.user, method)
+	return err
+}
+
+func (fs *trashFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if trash.IsTrashPath(name) {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs *trashFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if trash.IsTrashPath(name) {
+		return nil, os.ErrNotExist
+	}
+	if flag&os.O_TRUNC != 0 {
+		// 覆盖非空文件前保留旧内容，客户端上传前创建的空文件不保留
+		if info, err := fs.FileSystem.Stat(ctx, name); err == nil && info.Mode().IsRegular() && info.Size() > 0 {
+			if err = fs.put(ctx, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if path.Clean("/"+name) == "/" {
+		return &hidingFile{File: f, hidden: trash.Dir}, nil
+	}
+	return f, nil
+}
+
+func (fs *trashFileSystem) RemoveAll(ctx context.Context, name string) error {
+	if trash.IsTrashPath(name) {
+		return os.ErrNotExist
+	}
+	return fs.put(ctx, name)
+}
+
+func (fs *trashFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	if trash.IsTrashPath(oldName) || trash.IsTrashPath(newName) {
+		return os.ErrNotExist
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (fs *trashFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if trash.IsTrashPath(name) {
+		return nil, os.ErrNotExist
+	}
+	return fs.FileSystem.Stat(ctx, name)
+}
+
+// 根目录列表中不显示回收站、版本等内部目录
+type hidingFile struct {
+	webdav.File
+	hidden string
+}
+
+// 保留被包装文件的属性，如配额属性
+func (f *hidingFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	if dph, ok := f.File.(webdav.DeadPropsHolder); ok {
+		return dph.DeadProps()
+	}
+	return nil, nil
+}
+
+func (f *hidingFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	if dph, ok := f.File.(webdav.DeadPropsHolder); ok {
+		return dph.Patch(patches)
+	}
+	return forbidPatches(patches), nil
+}
+
+func (f *hidingFile) Readdir(count int) ([]os.FileInfo, error) {
+	children, err := f.File.Readdir(count)
+	return slices.DeleteFunc(children, func(child os.FileInfo) bool {
+		return child.Name() == f.hidden
+	}), err
+}
+
+// 覆盖文件前将旧内容保存为历史版本的文件系统，版本目录对客户端隐藏
+type versionFileSystem struct {
+	webdav.FileSystem
+	base  webdav.FileSystem // 回收站之下的文件系统
+	store *versions.Store
+}
+
+func (fs *versionFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if versions.IsVersionsPath(name) {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs *versionFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if versions.IsVersionsPath(name) {
+		return nil, os.ErrNotExist
+	}
+	files := fs.FileSystem
+	if flag&os.O_TRUNC != 0 {
+		// 与回收站相同，空文件不保存版本
+		if info, err := fs.FileSystem.Stat(ctx, name); err == nil && info.Mode().IsRegular() && info.Size() > 0 {
+			// 复制旧内容而不是移走，覆盖过程中文件不会消失，写入失败时可从版本恢复
+			if _, err = fs.store.Save(ctx, name); err != nil {
+				return nil, err
+			}
+			// 旧内容已保存为版本，不再放入回收站
+			files = fs.base
+		}
+	}
+	f, err := files.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if path.Clean("/"+name) == "/" {
+		return &hidingFile{File: f, hidden: versions.Dir}, nil
+	}
+	return f, nil
+}
+
+func (fs *versionFileSystem) RemoveAll(ctx context.Context, name string) error {
+	if versions.IsVersionsPath(name) {
+		return os.ErrNotExist
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fs *versionFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	if versions.IsVersionsPath(oldName) || versions.IsVersionsPath(newName) {
+		return os.ErrNotExist
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (fs *versionFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if versions.IsVersionsPath(name) {
+		return nil, os.ErrNotExist
+	}
+	return fs.FileSystem.Stat(ctx, name)
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/junlongzzz/file-download-agent/quota"
+	"github.com/junlongzzz/file-download-agent/trash"
+	"github.com/junlongzzz/file-download-agent/versions"
+	"golang.org/x/net/webdav"
+)
+
+func writeMemFile(t *testing.T, fs webdav.FileSystem, ctx context.Context, name string, size int) error {
+	t.Helper()
+	f, err := fs.OpenFile(ctx, name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write(make([]byte, size))
+	if closeErr := f.Close(); closeErr != nil {
+		t.Fatal(closeErr)
+	}
+	return err
+}
+
+func TestQuotaFileSystemAccounting(t *testing.T) {
+	ctx := context.Background()
+	mem := webdav.NewMemFS()
+	tracker := quota.NewTracker()
+	own, err := tracker.Usage(ctx, "/root", mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &quotaFileSystem{FileSystem: mem, root: "/root", tracker: tracker, own: own,
+		limits: []quota.Limit{{Usage: own, Bytes: 10}}}
+	used := func(want int64) {
+		t.Helper()
+		if got := tracker.Used(own); got != want {
+			t.Fatalf("used = %d, want %d", got, want)
+		}
+	}
+
+	if err = writeMemFile(t, fs, ctx, "/a.txt", 6); err != nil {
+		t.Fatal(err)
+	}
+	used(6)
+	// 覆盖时先释放旧内容的占用
+	if err = writeMemFile(t, fs, ctx, "/a.txt", 4); err != nil {
+		t.Fatal(err)
+	}
+	used(4)
+
+	// 超出配额时写入失败，标记为 507，不增加占用
+	flagged, exceeded := withQuotaFlag(ctx)
+	if err = writeMemFile(t, fs, flagged, "/b.txt", 7); !errors.Is(err, quota.ErrExceeded) || !exceeded.Load() {
+		t.Fatalf("write over quota error = %v, exceeded %v, want ErrExceeded", err, exceeded.Load())
+	}
+	used(4)
+	if got := fs.available(); got != 6 {
+		t.Errorf("available = %d, want 6", got)
+	}
+	// 不受配额限制的写入只统计占用
+	if err = writeMemFile(t, fs, withoutQuota(ctx), "/b.txt", 7); err != nil {
+		t.Fatal(err)
+	}
+	used(11)
+
+	if err = fs.Mkdir(ctx, "/dir", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.Rename(ctx, "/b.txt", "/dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	used(11)
+	if err = fs.RemoveAll(ctx, "/dir"); err != nil {
+		t.Fatal(err)
+	}
+	used(4)
+	if err = fs.RemoveAll(ctx, "/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	used(0)
+}
+
+// 只隐藏用户根目录下的回收站与版本目录，子目录中同名的目录属于用户自己
+func TestInternalDirsHiddenAtRootOnly(t *testing.T) {
+	ctx := context.Background()
+	mem := webdav.NewMemFS()
+	var fs webdav.FileSystem = &trashFileSystem{FileSystem: mem, bin: trash.NewBin(mem), user: "alice"}
+	fs = &versionFileSystem{FileSystem: fs, base: mem, store: versions.NewStore(mem, 1, 0)}
+
+	for _, dir := range []string{"/" + trash.Dir, "/" + versions.Dir} {
+		if err := fs.Mkdir(ctx, dir, 0o755); !os.IsPermission(err) {
+			t.Errorf("mkdir %s error = %v, want permission denied", dir, err)
+		}
+		if err := mem.Mkdir(ctx, dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.Stat(ctx, dir); !os.IsNotExist(err) {
+			t.Errorf("stat %s error = %v, want not exist", dir, err)
+		}
+	}
+	for _, dir := range []string{"/project", "/project/" + trash.Dir, "/project/" + versions.Dir} {
+		if err := fs.Mkdir(ctx, dir, 0o755); err != nil {
+			t.Fatalf("mkdir %s: %v", dir, err)
+		}
+	}
+	if err := writeMemFile(t, fs, ctx, "/project/"+versions.Dir+"/a.txt", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(dir string) []string {
+		t.Helper()
+		f, err := fs.OpenFile(ctx, dir, os.O_RDONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		children, err := f.Readdir(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list []string
+		for _, child := range children {
+			list = append(list, child.Name())
+		}
+		slices.Sort(list)
+		return list
+	}
+	if got := names("/"); !slices.Equal(got, []string{"project"}) {
+		t.Errorf("root entries = %v, want [project]", got)
+	}
+	if got, want := names("/project"), []string{trash.Dir, versions.Dir}; !slices.Equal(got, want) {
+		t.Errorf("/project entries = %v, want %v", got, want)
+	}
+}
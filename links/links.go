@@ -0,0 +1,338 @@
+package links
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 链接不可用的原因
+var (
+	ErrNotFound  = errors.New("link not found")
+	ErrRevoked   = errors.New("link has been revoked")
+	ErrExhausted = errors.New("link has reached its max uses")
+)
+
+// 链接状态
+const (
+	StatusActive    = "active"
+	StatusRevoked   = "revoked"
+	StatusExpired   = "expired"
+	StatusExhausted = "exhausted"
+)
+
+// 使用次数与流量统计的落盘间隔
+const flushInterval = 5 * time.Second
+
+// 同一客户端开始下载后该时长内带 Range 头的请求不重复计数，用于断点续传与分段下载
+const resumeWindow = time.Hour
+
+// 超过该数量时清理过期的下载记录
+const maxSessions = 4096
+
+// Link 服务端保存的下载链接
+type Link struct {
+	ID        string     `json:"id"`
+	Url       string     `json:"url"`
+	Filename  string     `json:"filename,omitempty"`
+	Expire    int64      `json:"expire,omitempty"`   // 过期时间戳，单位：秒，0 表示不过期
+	MaxUses   int64      `json:"max_uses,omitempty"` // 最大下载次数，0 表示不限制
+	Note      string     `json:"note,omitempty"`
+	Creator   string     `json:"creator,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+
+	Uses       int64      `json:"uses"`  // 已下载次数
+	Bytes      int64      `json:"bytes"` // 已发送字节数
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Status 返回链接当前状态
+func (l *Link) Status(now time.Time) string {
+	switch {
+	case l.RevokedAt != nil:
+		return StatusRevoked
+	case l.Expire > 0 && now.After(time.Unix(l.Expire, 0)):
+		return StatusExpired
+	case l.MaxUses > 0 && l.Uses >= l.MaxUses:
+		return StatusExhausted
+	default:
+		return StatusActive
+	}
+}
+
+// Stats 链接统计
+type Stats struct {
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"by_status"`
+	Uses     int64          `json:"uses"`
+	Bytes    int64          `json:"bytes"`
+}
+
+// Store 保存在 JSON 文件中的链接
+// 创建与撤销立即落盘，使用次数与流量定期落盘
+type Store struct {
+	path string
+
+	mu    sync.Mutex
+	links map[string]*Link
+	dirty bool
+	// 链接ID与客户端到首次计数时间，不落盘
+	sessions map[string]time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open 从文件加载链接，文件不存在时创建空的存储
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, links: make(map[string]*Link), sessions: make(map[string]time.Time), done: make(chan struct{})}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read links file: %w", err)
+	}
+	if len(data) > 0 {
+		var list []*Link
+		if err = json.Unmarshal(data, &list); err != nil {
+			return nil, fmt.Errorf("parse links file: %w", err)
+		}
+		for _, l := range list {
+			s.links[l.ID] = l
+		}
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create links directory: %w", err)
+	}
+	s.wg.Add(1)
+	go s.flushLoop()
+	return s, nil
+}
+
+// Create 保存新链接，自动生成ID与创建时间
+func (s *Store) Create(l Link) (*Link, error) {
+	l.ID = newID()
+	l.CreatedAt = time.Now()
+	l.RevokedAt, l.LastUsedAt, l.Uses, l.Bytes = nil, nil, 0, 0
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.links[l.ID] = &l
+	if err := s.save(); err != nil {
+		delete(s.links, l.ID)
+		return nil, err
+	}
+	cp := l
+	return &cp, nil
+}
+
+// Get 返回链接副本
+func (s *Store) Get(id string) (*Link, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[id]
+	if !ok {
+		return nil, false
+	}
+	cp := *l
+	return &cp, true
+}
+
+// List 按创建时间倒序返回链接，status 为空时返回全部
+func (s *Store) List(status string) []*Link {
+	now := time.Now()
+	s.mu.Lock()
+	list := make([]*Link, 0, len(s.links))
+	for _, l := range s.links {
+		if status == "" || l.Status(now) == status {
+			cp := *l
+			list = append(list, &cp)
+		}
+	}
+	s.mu.Unlock()
+	slices.SortFunc(list, func(a, b *Link) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return list
+}
+
+// Revoke 撤销链接，已撤销时保持原撤销时间
+func (s *Store) Revoke(id string) (*Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if l.RevokedAt == nil {
+		now := time.Now()
+		l.RevokedAt = &now
+		if err := s.save(); err != nil {
+			l.RevokedAt = nil
+			return nil, err
+		}
+	}
+	cp := *l
+	return &cp, nil
+}
+
+// Check 检查链接是否可用
+func (s *Store) Check(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return availability(l)
+}
+
+// Use 检查链接是否可用并记录一次下载
+// 每次开始下载都计数，resume 表示请求带有 Range 头，
+// 同一客户端已计数的下载在 resumeWindow 内续传时不重复计数
+func (s *Store) Use(id, client string, resume bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[id]
+	if !ok {
+		return ErrNotFound
+	}
+	now := time.Now()
+	key := id + "|" + client
+	if started, ok := s.sessions[key]; resume && ok && now.Sub(started) < resumeWindow {
+		// 已计数的下载，只检查是否被撤销
+		if l.RevokedAt != nil {
+			return ErrRevoked
+		}
+		return nil
+	}
+	if err := availability(l); err != nil {
+		return err
+	}
+	l.Uses++
+	l.LastUsedAt = &now
+	s.dirty = true
+	s.sessions[key] = now
+	if len(s.sessions) > maxSessions {
+		for k, started := range s.sessions {
+			if now.Sub(started) >= resumeWindow {
+				delete(s.sessions, k)
+			}
+		}
+	}
+	return nil
+}
+
+// 过期由下载参数中的 expire 校验，这里只检查撤销与次数
+func availability(l *Link) error {
+	if l.RevokedAt != nil {
+		return ErrRevoked
+	}
+	if l.MaxUses > 0 && l.Uses >= l.MaxUses {
+		return ErrExhausted
+	}
+	return nil
+}
+
+// AddBytes 累加链接已发送的字节数，s 为空时忽略
+func (s *Store) AddBytes(id string, n int64) {
+	if s == nil || n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.links[id]; ok {
+		l.Bytes += n
+		s.dirty = true
+	}
+}
+
+// Stats 返回所有链接的统计
+func (s *Store) Stats() Stats {
+	now := time.Now()
+	stats := Stats{ByStatus: map[string]int{
+		StatusActive: 0, StatusRevoked: 0, StatusExpired: 0, StatusExhausted: 0,
+	}}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.links {
+		stats.Total++
+		stats.ByStatus[l.Status(now)]++
+		stats.Uses += l.Uses
+		stats.Bytes += l.Bytes
+	}
+	return stats
+}
+
+// 写入临时文件后替换，避免写入中断损坏已有数据，调用方需持有锁
+func (s *Store) save() error {
+	list := make([]*Link, 0, len(s.links))
+	for _, l := range s.links {
+		list = append(list, l)
+	}
+	slices.SortFunc(list, func(a, b *Link) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write links file: %w", err)
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("write links file: %w", err)
+	}
+	s.dirty = false
+	return nil
+}
+
+// 定期保存使用次数与流量
+func (s *Store) flushLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Store) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dirty {
+		if err := s.save(); err != nil {
+			slog.Error(fmt.Sprintf("Save links error: %v", err))
+		}
+	}
+}
+
+// Close 停止定期保存并保存未落盘的统计
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	close(s.done)
+	s.wg.Wait()
+	s.flush()
+	return nil
+}
+
+// 随机链接ID
+func newID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
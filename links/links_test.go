@@ -0,0 +1,60 @@
+package links
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestUseCountsEachDownload(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "links.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	l, err := s.Create(Link{Url: "https://example.com/a.zip", MaxUses: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uses := func(want int64) {
+		t.Helper()
+		if got, _ := s.Get(l.ID); got.Uses != want {
+			t.Fatalf("uses = %d, want %d", got.Uses, want)
+		}
+	}
+
+	if err = s.Use(l.ID, "1.2.3.4", false); err != nil {
+		t.Fatal(err)
+	}
+	uses(1)
+	// 续传已计数的下载不重复计数
+	if err = s.Use(l.ID, "1.2.3.4", true); err != nil {
+		t.Fatal(err)
+	}
+	uses(1)
+	// 同一IP重新开始下载需要计数
+	if err = s.Use(l.ID, "1.2.3.4", false); err != nil {
+		t.Fatal(err)
+	}
+	uses(2)
+	if err = s.Use(l.ID, "1.2.3.4", false); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("use beyond max uses error = %v, want ErrExhausted", err)
+	}
+	// 未计数过的客户端带 Range 头也需要计数
+	if err = s.Use(l.ID, "5.6.7.8", true); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("range request of a new client error = %v, want ErrExhausted", err)
+	}
+	// 已开始的下载仍可续传，撤销后不可以
+	if err = s.Use(l.ID, "1.2.3.4", true); err != nil {
+		t.Fatalf("resume after exhausted: %v", err)
+	}
+	if _, err = s.Revoke(l.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Use(l.ID, "1.2.3.4", true); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("resume after revoke error = %v, want ErrRevoked", err)
+	}
+	if err = s.Use("missing", "1.2.3.4", false); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("use missing link error = %v, want ErrNotFound", err)
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"embed"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -10,18 +13,42 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/junlongzzz/file-download-agent/accesslog"
+	"github.com/junlongzzz/file-download-agent/audit"
 	"github.com/junlongzzz/file-download-agent/common"
+	"github.com/junlongzzz/file-download-agent/config"
+	"github.com/junlongzzz/file-download-agent/davlock"
 	"github.com/junlongzzz/file-download-agent/handler"
+	"github.com/junlongzzz/file-download-agent/links"
+	"github.com/junlongzzz/file-download-agent/metrics"
+	"github.com/junlongzzz/file-download-agent/passwd"
+	"github.com/junlongzzz/file-download-agent/storage"
+	"github.com/junlongzzz/file-download-agent/tracing"
+	"github.com/junlongzzz/file-download-agent/trash"
+	"github.com/junlongzzz/file-download-agent/webhook"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
 )
 
 var (
+	// 实际使用的下载目录与webdav目录
+	downloadDir, webDavDir string
+	webDavStorage          storage.Storage
+
 	downloadHandler *handler.DownloadHandler
 	webDavHandler   *handler.WebDavHandler
 	staticHandler   *handler.StaticHandler
+	healthHandler   *handler.HealthHandler
+	accessLogger    *accesslog.Logger
+	notifier        *webhook.Notifier
+	auditStore      *audit.Store
+	webDavLocks     *davlock.LockSystem
+	webDavTrash     *trash.Manager
+	linkStore       *links.Store
 
 	//go:embed static/*
 	static embed.FS
@@ -31,166 +58,590 @@ var (
 func main() {
 	versionInfo := fmt.Sprintf("File Download Agent v%s (%s %s/%s)", common.Version(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
 
-	// 从环境变量内读取运行参数
-	host := os.Getenv("FDA_HOST")
-	port, _ := strconv.Atoi(os.Getenv("FDA_PORT"))
-	signKey := os.Getenv("FDA_SIGN_KEY")
-	dir := os.Getenv("FDA_DIR")
-	logLevel := os.Getenv("FDA_LOG_LEVEL")
-	webDavEnableEnv := os.Getenv("FDA_WEBDAV_ENABLE")
-	// 默认启用webdav
-	webDavEnable := true
-	if webDavEnableEnv != "" {
-		webDavEnable, _ = strconv.ParseBool(webDavEnableEnv)
-	}
-	webDavDir := os.Getenv("FDA_WEBDAV_DIR")
-	webDavUser := os.Getenv("FDA_WEBDAV_USER")
-	webDavPass := os.Getenv("FDA_WEBDAV_PASS")
-	certFile := os.Getenv("FDA_CERT_FILE")
-	certKeyFile := os.Getenv("FDA_CERT_KEY_FILE")
-	// 从运行参数中获取运行参数
-	// 会覆盖环境变量的值，如果不存在默认就使用环境变量内的值
-	flag.StringVar(&host, "host", host, "server host (default 0.0.0.0)")
-	flag.IntVar(&port, "port", port, "server port (default 18080)")
-	flag.StringVar(&signKey, "sign-key", signKey, "server download sign key")
-	flag.StringVar(&dir, "dir", dir, "download directory (default ./files)")
-	flag.BoolVar(&webDavEnable, "webdav-enable", webDavEnable, "enable webdav server or not")
-	flag.StringVar(&webDavDir, "webdav-dir", webDavDir, "webdav root directory (default <dir>)")
-	flag.StringVar(&webDavUser, "webdav-user", webDavUser, "webdav username (default anonymous)")
-	flag.StringVar(&webDavPass, "webdav-pass", webDavPass, "webdav password (default <sign_key>)")
-	flag.StringVar(&logLevel, "log-level", logLevel, "log level: debug, info, warn, error (default info)")
-	flag.StringVar(&certFile, "cert-file", certFile, "cert file path")
-	flag.StringVar(&certKeyFile, "cert-key-file", certKeyFile, "cert key file path")
-	var version bool
-	flag.BoolVar(&version, "version", false, "show version")
-	// 解析命令行参数
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		// 子命令: fda config print [flags]
+		configCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "passwd" {
+		// 子命令: fda passwd [flags] [user]
+		passwdCommand(os.Args[2:])
+		return
+	}
 
+	// 加载配置 优先级：配置文件 < 环境变量 < 运行参数
+	loader := config.NewLoader(os.Args[0])
+	var version bool
+	loader.FlagSet.BoolVar(&version, "version", false, "show version")
+	cfg, err := loader.Load(os.Args[1:])
 	if version {
 		fmt.Println(versionInfo)
 		os.Exit(0)
 	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	// 设置日志输出级别
-	var slogLevel slog.Level
-	switch strings.ToLower(logLevel) {
-	case "debug":
-		slogLevel = slog.LevelDebug
-	case "info":
-		slogLevel = slog.LevelInfo
-	case "warn":
-		slogLevel = slog.LevelWarn
-	case "error":
-		slogLevel = slog.LevelError
-	default:
-		slogLevel = slog.LevelInfo
-	}
-	slog.SetLogLoggerLevel(slogLevel)
+	setLogLevel(cfg.LogLevel)
 
 	slog.Info(versionInfo)
+	if loader.File() != "" {
+		slog.Info(fmt.Sprintf("Config file: %s", loader.File()))
+	}
 
-	if signKey != "" {
+	if cfg.SignKey != "" {
 		slog.Info("Sign key has been set")
 	}
 
+	dir := cfg.Dir
 	if dir == "" {
 		// 默认下载目录为当前程序执行目录
-		executable, err := os.Executable()
-		if err == nil {
-			dir = filepath.Join(filepath.Dir(executable), "files")
-			// 判断文件夹是否存在，否则创建
-			if _, err := os.Stat(dir); os.IsNotExist(err) {
-				// 文件夹不存在，创建
-				if err := os.Mkdir(dir, os.ModePerm); err != nil {
-					slog.Error(fmt.Sprintf("Create directory error: %v", err))
-					os.Exit(1)
-				}
-			}
-		} else {
-			slog.Error(fmt.Sprintf("Get executable path error: %v", err))
-			os.Exit(1)
-		}
+		dir = defaultDir("files")
+	}
+	downloadDir = dir
+	// 非本地存储时下载与 WebDAV 共用存储的根目录
+	fileStorage, err := storage.Open(cfg.StorageOptions(dir))
+	if err != nil {
+		slog.Error(fmt.Sprintf("Open storage error: %v", err))
+		os.Exit(1)
+	}
+	if cfg.Storage.Type == storage.TypeLocal {
+		slog.Info(fmt.Sprintf("Download directory: %s", dir))
+	} else {
+		slog.Info(fmt.Sprintf("Storage: %s (%s)", cfg.Storage.Type, fileStorage.Root()))
 	}
-	slog.Info(fmt.Sprintf("Download directory: %s", dir))
 
-	if webDavEnable {
+	if cfg.WebDav.Enable {
 		slog.Info("WebDAV is enabled")
+		webDavDir = cfg.WebDav.Dir
 		if webDavDir == "" {
 			// 未设置webdav目录，使用下载目录
 			webDavDir = dir
 		}
-		slog.Info(fmt.Sprintf("WebDAV directory: %s", webDavDir))
-		if webDavUser == "" {
-			// 未设置webdav用户名，使用匿名用户
-			webDavUser = "anonymous"
+		webDavStorage = fileStorage
+		if cfg.Storage.Type == storage.TypeLocal {
+			if webDavStorage, err = storage.NewLocal(webDavDir); err != nil {
+				slog.Error(fmt.Sprintf("Open WebDAV directory error: %v", err))
+				os.Exit(1)
+			}
+			slog.Info(fmt.Sprintf("WebDAV directory: %s", webDavDir))
 		}
-		if webDavPass == "" && signKey != "" {
-			// 未设置webdav密码，使用signKey
-			webDavPass = signKey
+		// 锁保存在本地数据库中，重启后仍然有效
+		lockFile := cfg.WebDav.LockFile
+		if lockFile == "" {
+			lockFile = filepath.Join(defaultDir("data"), "webdav-locks.db")
 		}
+		if webDavLocks, err = davlock.Open(lockFile); err != nil {
+			slog.Error(fmt.Sprintf("Open WebDAV lock file error: %v", err))
+			os.Exit(1)
+		}
+		slog.Info(fmt.Sprintf("WebDAV lock file: %s", lockFile))
+		webDavTrash = trash.NewManager(cfg.WebDav.TrashRetention)
 		// 初始化 webdav handler
-		webDavHandler = handler.NewWebDavHandler(webDavDir, webDavUser, webDavPass)
+		webDavUser, webDavPass := webDavAuth(cfg)
+		if webDavHandler, err = handler.NewWebDavHandler(webDavStorage, webDavLocks, webDavUser, webDavPass); err != nil {
+			slog.Error(fmt.Sprintf("Create WebDAV handler error: %v", err))
+			os.Exit(1)
+		}
 	} else {
 		slog.Info("WebDAV is disabled")
 		webDavHandler = nil
 	}
 
 	// 初始化handler
-	downloadHandler = handler.NewDownloadHandler(dir, signKey)
+	downloadHandler = handler.NewDownloadHandler(fileStorage, cfg.SignKey)
+	accessLogger = &accesslog.Logger{}
+	notifier = webhook.NewNotifier(cfg.Webhook.QueueSize)
+	downloadHandler.SetNotifier(notifier)
+	if webDavHandler != nil {
+		// 分享的 WebDAV 文件通过 /download 下载
+		downloadHandler.SetWebDavStorage(webDavStorage)
+	}
+	// 管理接口与 WebDAV 分享创建的链接保存在服务端
+	if cfg.Admin.Token != "" || (webDavHandler != nil && cfg.WebDav.Share) {
+		linksFile := cfg.Admin.LinksFile
+		if linksFile == "" {
+			linksFile = filepath.Join(defaultDir("data"), "links.json")
+		}
+		if linkStore, err = links.Open(linksFile); err != nil {
+			slog.Error(fmt.Sprintf("Open links file error: %v", err))
+			os.Exit(1)
+		}
+		slog.Info(fmt.Sprintf("Links file: %s", linksFile))
+		downloadHandler.SetLinkStore(linkStore)
+	}
+	if err = applyConfig(cfg); err != nil {
+		slog.Error(fmt.Sprintf("Apply config error: %v", err))
+		os.Exit(1)
+	}
 	staticHandler = handler.NewStaticHandler(static)
+	if cfg.Audit.Enable {
+		auditDir := cfg.Audit.Dir
+		if auditDir == "" {
+			auditDir = defaultDir("audit")
+		}
+		if auditStore, err = audit.Open(auditDir, cfg.Audit.SegmentSize); err != nil {
+			slog.Error(fmt.Sprintf("Open audit log error: %v", err))
+			os.Exit(1)
+		}
+		slog.Info(fmt.Sprintf("Audit log directory: %s", auditDir))
+		downloadHandler.SetAuditLog(auditStore)
+		if webDavHandler != nil {
+			webDavHandler.SetAuditLog(auditStore)
+		}
+	}
+	healthHandler = handler.NewHealthHandler()
+	applyReadiness(cfg)
+
+	// 初始化链路追踪
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.Tracing.Enable {
+		if shutdownTracing, err = tracing.Setup(context.Background(), cfg.TracingOptions()); err != nil {
+			slog.Error(fmt.Sprintf("Setup tracing error: %v", err))
+			os.Exit(1)
+		}
+		slog.Info(fmt.Sprintf("Tracing is enabled, exporting to %s", cfg.Tracing.Endpoint))
+	}
 
 	// 启动服务器
-	server(host, port, certFile, certKeyFile)
+	httpServer := server(cfg)
+	var metricsServer *http.Server
+	if cfg.Metrics.Enable && cfg.Metrics.Listen != "" {
+		metricsServer = serveMetrics(cfg.Metrics)
+	}
 
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-	signalReceived := <-signalChan
-	slog.Info(fmt.Sprintf("Server stopped (signal: %v)", signalReceived))
-	os.Exit(0)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for signalReceived := range signalChan {
+		if signalReceived == syscall.SIGHUP {
+			// 重新加载配置，进行中的下载继续使用旧配置
+			cfg = reloadConfig(loader, cfg)
+			continue
+		}
+		slog.Info(fmt.Sprintf("Server stopping (signal: %v)", signalReceived))
+		shutdown(httpServer, cfg.ShutdownDrainDelay, cfg.ShutdownTimeout, signalChan)
+		if metricsServer != nil {
+			_ = metricsServer.Close()
+		}
+		_ = accessLogger.Close()
+		_ = auditStore.Close()
+		_ = linkStore.Close()
+		_ = webDavLocks.Close()
+		_ = webDavTrash.Close()
+		// 推送剩余的 webhook 事件并导出剩余的链路数据
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := notifier.Close(ctx); err != nil {
+			slog.Warn(fmt.Sprintf("Flush webhook events error: %v", err))
+		}
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn(fmt.Sprintf("Flush traces error: %v", err))
+		}
+		cancel()
+		os.Exit(0)
+	}
 }
 
-// 启动HTTP服务器
-func server(host string, port int, certFile, keyFile string) {
-	if port <= 0 || port >= 65535 {
-		// 不合法端口号，重置为默认端口
-		port = 18080
+// 优雅停止服务器，拒绝新连接并等待进行中的下载完成
+// 超过 timeout 或再次收到停止信号时强制中断剩余连接
+func shutdown(httpServer *http.Server, drainDelay, timeout time.Duration, signalChan <-chan os.Signal) {
+	healthHandler.SetDraining(true)
+	// 再次收到停止信号时跳过等待并中断进行中的下载
+	abort, cancelAbort := context.WithCancel(context.Background())
+	defer cancelAbort()
+	go func() {
+		for sig := range signalChan {
+			if sig != syscall.SIGHUP {
+				slog.Warn(fmt.Sprintf("Received %v again, aborting in-flight downloads", sig))
+				cancelAbort()
+				return
+			}
+		}
+	}()
+	if drainDelay > 0 {
+		// 健康检查已返回 draining，继续接收新请求，等待负载均衡摘除实例
+		slog.Info(fmt.Sprintf("Waiting %s before closing listeners", drainDelay))
+		select {
+		case <-time.After(drainDelay):
+		case <-abort.Done():
+		}
 	}
 
+	inFlight := downloadHandler.Active()
+	slog.Info(fmt.Sprintf("Draining %d in-flight downloads (timeout: %s)", inFlight, timeout))
+	ctx, cancel := context.WithTimeout(abort, timeout)
+	defer cancel()
+
+	err := httpServer.Shutdown(ctx)
+	aborted := int64(0)
+	if err != nil {
+		// 等待超时，强制关闭剩余连接
+		aborted = downloadHandler.Active()
+		_ = httpServer.Close()
+	}
+	slog.Info(fmt.Sprintf("Server stopped: %d downloads drained, %d aborted", inFlight-aborted, aborted))
+}
+
+// 程序执行目录下的默认目录，不存在时创建
+func defaultDir(name string) string {
+	executable, err := os.Executable()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Get executable path error: %v", err))
+		os.Exit(1)
+	}
+	dir := filepath.Join(filepath.Dir(executable), name)
+	// 判断文件夹是否存在，否则创建
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		// 文件夹不存在，创建
+		if err := os.Mkdir(dir, os.ModePerm); err != nil {
+			slog.Error(fmt.Sprintf("Create directory error: %v", err))
+			os.Exit(1)
+		}
+	}
+	return dir
+}
+
+// 设置日志输出级别
+func setLogLevel(logLevel string) {
+	var slogLevel slog.Level
+	switch strings.ToLower(logLevel) {
+	case "debug":
+		slogLevel = slog.LevelDebug
+	case "warn":
+		slogLevel = slog.LevelWarn
+	case "error":
+		slogLevel = slog.LevelError
+	default:
+		slogLevel = slog.LevelInfo
+	}
+	slog.SetLogLoggerLevel(slogLevel)
+}
+
+// 获取 webdav basic 认证信息
+func webDavAuth(cfg *config.Config) (username, password string) {
+	username, password = cfg.WebDav.User, cfg.WebDav.Pass
+	if username == "" {
+		// 未设置webdav用户名，使用匿名用户
+		username = "anonymous"
+	}
+	if password == "" && cfg.SignKey != "" {
+		// 未设置webdav密码，使用signKey
+		password = cfg.SignKey
+	}
+	return username, password
+}
+
+// 将可热更新的配置应用到各个handler
+// 先创建并校验所有组件，全部成功后再一起替换，任一项失败时保持当前配置不变
+func applyConfig(cfg *config.Config) error {
+	downloadOpts, err := cfg.DownloadOptions()
+	if err != nil {
+		return err
+	}
+	applyDownload, err := downloadHandler.Prepare(downloadOpts)
+	if err != nil {
+		return err
+	}
+	var applyWebDav func()
+	var webDavUserCount int
+	if webDavHandler != nil {
+		webDavUsers, err := cfg.WebDavUsers()
+		if err != nil {
+			return err
+		}
+		webDavUserCount = len(webDavUsers)
+		if len(webDavUsers) == 0 {
+			// 未配置多用户时使用单用户配置
+			if username, password := webDavAuth(cfg); username != "" && password != "" {
+				webDavUsers = []handler.WebDavUser{{Username: username, Password: password}}
+			}
+		}
+		opts := handler.WebDavOptions{
+			Users:         webDavUsers,
+			AuthCacheTTL:  cfg.WebDav.AuthCacheTTL,
+			ReadOnly:      cfg.WebDav.ReadOnly,
+			Quota:         cfg.WebDav.Quota,
+			Versions:      cfg.WebDav.Versions,
+			VersionMaxAge: cfg.WebDav.VersionMaxAge,
+		}
+		if cfg.WebDav.Trash {
+			opts.Trash = webDavTrash
+		}
+		if applyWebDav, err = webDavHandler.Prepare(opts); err != nil {
+			return err
+		}
+	}
+	// 最后打开日志文件，之后不会再失败
+	applyAccessLog, err := accessLogger.Prepare(cfg.AccessLogOptions())
+	if err != nil {
+		return err
+	}
+
+	applyDownload()
+	applyAccessLog()
+	notifier.SetOptions(cfg.WebhookOptions())
+	for _, u := range cfg.Webhook.Urls {
+		slog.Info(fmt.Sprintf("Webhook: %s", common.RedactURL(u)))
+	}
+	for _, rule := range downloadOpts.Client.ProxyRules {
+		slog.Info(fmt.Sprintf("Proxy rule: %s", rule))
+	}
+	if len(downloadOpts.Client.InsecureHosts) > 0 {
+		slog.Warn(fmt.Sprintf("TLS verification disabled for upstream hosts: %s", strings.Join(downloadOpts.Client.InsecureHosts, ", ")))
+	}
+	if webDavHandler != nil {
+		webDavTrash.SetRetention(cfg.WebDav.TrashRetention)
+		applyWebDav()
+		if cfg.WebDav.Share && linkStore != nil {
+			webDavHandler.SetShare(downloadHandler, linkStore)
+		} else {
+			webDavHandler.SetShare(nil, nil)
+			if cfg.WebDav.Share {
+				// 启动时未打开链接保存文件
+				slog.Warn("WebDAV share requires a restart to take effect")
+			}
+		}
+		if cfg.WebDav.ReadOnly {
+			slog.Info("WebDAV is read-only")
+		}
+		if webDavUserCount > 0 {
+			slog.Info(fmt.Sprintf("WebDAV users: %d", webDavUserCount))
+		}
+	}
+	if healthHandler != nil {
+		applyReadiness(cfg)
+	}
+	setLogLevel(cfg.LogLevel)
+	return nil
+}
+
+// 设置就绪检查配置
+func applyReadiness(cfg *config.Config) {
+	// 只检查本地存储的目录
+	dirs := map[string]string{}
+	if cfg.Storage.Type == storage.TypeLocal {
+		dirs["download_dir"] = downloadDir
+		if webDavHandler != nil {
+			dirs["webdav_dir"] = webDavDir
+		}
+	}
+	healthHandler.SetReadiness(handler.ReadinessOptions{
+		Dirs:            dirs,
+		MinFreeSpace:    cfg.Health.MinFreeSpace,
+		UpstreamUrl:     cfg.Health.UpstreamUrl,
+		UpstreamTimeout: cfg.Health.UpstreamTimeout,
+		Client:          downloadHandler.Client(),
+	})
+}
+
+// 重新加载配置，校验或应用失败时保留当前配置，返回生效的配置
+func reloadConfig(loader *config.Loader, current *config.Config) *config.Config {
+	slog.Info("Reloading config")
+	cfg, err := loader.Reload()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Reload config rejected: %v", err))
+		return current
+	}
+	if err = applyConfig(cfg); err != nil {
+		slog.Error(fmt.Sprintf("Reload config rejected: %v", err))
+		return current
+	}
+	// 监听地址、目录等配置需要重启才能生效
+	var restart []string
+	for _, item := range []struct {
+		name    string
+		changed bool
+	}{
+		{"host", cfg.Host != current.Host},
+		{"port", cfg.Port != current.Port},
+		{"cert", cfg.CertFile != current.CertFile || cfg.CertKeyFile != current.CertKeyFile},
+		{"dir", cfg.Dir != current.Dir},
+		{"webdav enable/dir/lock file", cfg.WebDav.Enable != current.WebDav.Enable || cfg.WebDav.Dir != current.WebDav.Dir || cfg.WebDav.LockFile != current.WebDav.LockFile},
+		{"metrics", cfg.Metrics != current.Metrics},
+		{"tracing", cfg.Tracing != current.Tracing},
+		{"webhook queue size", cfg.Webhook.QueueSize != current.Webhook.QueueSize},
+		{"admin", cfg.Admin != current.Admin},
+		{"audit", cfg.Audit != current.Audit},
+		{"storage", cfg.Storage != current.Storage},
+	} {
+		if item.changed {
+			restart = append(restart, item.name)
+		}
+	}
+	if len(restart) > 0 {
+		slog.Warn(fmt.Sprintf("Changes to %s require a restart to take effect", strings.Join(restart, ", ")))
+	}
+	slog.Info("Config reloaded")
+	return cfg
+}
+
+// config 子命令
+func configCommand(args []string) {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: fda config print [flags]")
+		os.Exit(2)
+	}
+	// 输出合并后的有效配置，敏感信息已隐藏
+	loader := config.NewLoader("fda config print")
+	cfg, err := loader.Load(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	out, err := config.Marshal(cfg.Redacted())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Print(string(out))
+}
+
+// passwd 子命令，生成 WebDAV 密码哈希
+// 指定用户名时输出 htpasswd 格式的一行
+func passwdCommand(args []string) {
+	fs := flag.NewFlagSet("fda passwd", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: fda passwd [flags] [user]")
+		fs.PrintDefaults()
+	}
+	algorithm := fs.String("algo", passwd.Bcrypt, "hash algorithm: bcrypt, argon2id")
+	cost := fs.Int("cost", bcrypt.DefaultCost, "bcrypt cost")
+	_ = fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var password string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		first, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprint(os.Stderr, "Confirm password: ")
+		second, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if string(first) != string(second) {
+			fmt.Fprintln(os.Stderr, "passwords do not match")
+			os.Exit(1)
+		}
+		password = string(first)
+	} else {
+		// 非交互时从标准输入读取第一行
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(os.Stderr, "read password from stdin:", err)
+			os.Exit(1)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "password must not be empty")
+		os.Exit(1)
+	}
+
+	hash, err := passwd.Hash(password, *algorithm, *cost)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if user := fs.Arg(0); user != "" {
+		fmt.Printf("%s:%s\n", user, hash)
+	} else {
+		fmt.Println(hash)
+	}
+}
+
+// 启动HTTP服务器
+func server(cfg *config.Config) *http.Server {
 	// 创建路由器
 	serveMux := http.NewServeMux()
 	// 注册默认根路径路由
 	serveMux.Handle("/", staticHandler)
 	// 注册访问路由
 	serveMux.Handle("/download", downloadHandler)
+	serveMux.Handle("/healthz", healthHandler)
+	serveMux.Handle("/readyz", healthHandler)
+	serveMux.Handle("/version", healthHandler)
 	if webDavHandler != nil {
 		serveMux.Handle("/webdav/", webDavHandler)
 	}
+	if cfg.Admin.Token != "" {
+		serveMux.Handle("/admin/api/", common.BearerAuth(cfg.Admin.Token, "admin", handler.NewAdminHandler(downloadHandler, linkStore)))
+		slog.Info("Admin API: /admin/api/")
+		if webDavLocks != nil {
+			serveMux.Handle("/admin/webdav/locks", common.BearerAuth(cfg.Admin.Token, "admin", handler.NewLockHandler(webDavLocks)))
+		}
+		if webDavTrash != nil {
+			trashHandler := common.BearerAuth(cfg.Admin.Token, "admin", handler.NewTrashHandler(webDavTrash))
+			serveMux.Handle("/admin/webdav/trash", trashHandler)
+			serveMux.Handle("/admin/webdav/trash/", trashHandler)
+		}
+		if auditStore != nil {
+			serveMux.Handle("/admin/audit", common.BearerAuth(cfg.Admin.Token, "admin", handler.NewAuditHandler(auditStore)))
+		}
+	} else if auditStore != nil {
+		slog.Warn("Admin token is not set, /admin/audit is disabled")
+	}
+	if cfg.Metrics.Enable && cfg.Metrics.Listen == "" {
+		// 未设置独立监听地址，与主服务共用
+		serveMux.Handle(cfg.Metrics.Path, metrics.Handler(cfg.Metrics.Token))
+		slog.Info(fmt.Sprintf("Metrics endpoint: %s", cfg.Metrics.Path))
+	}
+
+	// 创建服务器
+	// 支持 h2c 的服务器，兼容 http/1.1
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	httpServer := &http.Server{
+		Addr:      fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Handler:   accessLogger.Middleware(metrics.Middleware(tracing.Middleware(serveMux))),
+		Protocols: protocols,
+	}
 
 	go func() {
 		// 启动HTTP服务器 异步
-		// 创建服务器
-		// 支持 h2c 的服务器，兼容 http/1.1
-		protocols := new(http.Protocols)
-		protocols.SetHTTP1(true)
-		protocols.SetHTTP2(true)
-		protocols.SetUnencryptedHTTP2(true)
-		httpServer := &http.Server{
-			Addr:      fmt.Sprintf("%s:%d", host, port),
-			Handler:   serveMux,
-			Protocols: protocols,
-		}
 		var err error
-		if certFile != "" && keyFile != "" {
+		if cfg.CertFile != "" && cfg.CertKeyFile != "" {
 			// 支持 https 的服务器
 			slog.Info(fmt.Sprintf("Server is running on %s with HTTPS", httpServer.Addr))
-			err = httpServer.ListenAndServeTLS(certFile, keyFile)
+			err = httpServer.ListenAndServeTLS(cfg.CertFile, cfg.CertKeyFile)
 		} else {
 			slog.Info(fmt.Sprintf("Server is running on %s", httpServer.Addr))
 			err = httpServer.ListenAndServe()
 		}
-		if err != nil {
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			slog.Error(fmt.Sprintf("Server start error: %v", err))
 			os.Exit(1)
 		}
 	}()
+	return httpServer
+}
+
+// 在独立地址上启动监控指标服务器
+func serveMetrics(cfg config.MetricsConfig) *http.Server {
+	serveMux := http.NewServeMux()
+	serveMux.Handle(cfg.Path, metrics.Handler(cfg.Token))
+	metricsServer := &http.Server{
+		Addr:    cfg.Listen,
+		Handler: serveMux,
+	}
+	go func() {
+		slog.Info(fmt.Sprintf("Metrics server is running on %s%s", metricsServer.Addr, cfg.Path))
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error(fmt.Sprintf("Metrics server start error: %v", err))
+			os.Exit(1)
+		}
+	}()
+	return metricsServer
 }
@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/junlongzzz/file-download-agent/common"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fda"
+
+// 下载来源类型
+const (
+	SourceUrl    = "url"
+	SourceFile   = "file"
+	SourceWebDav = "webdav"
+)
+
+// 签名校验失败原因
+const (
+	SignatureEncDecode  = "enc_decode"  // enc 参数 base64 解码失败
+	SignatureEncDecrypt = "enc_decrypt" // enc 参数解密失败（密钥错误或被篡改）
+	SignatureEncPayload = "enc_payload" // enc 解密后的数据格式错误
+	SignatureMismatch   = "sign_mismatch"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	// RequestsTotal 按路由、方法、状态码统计的请求数
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	// RequestDuration 按路由统计的请求耗时
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request duration by route.",
+		Buckets:   []float64{0.005, 0.025, 0.1, 0.5, 1, 5, 15, 60, 300, 900},
+	}, []string{"route"})
+
+	// BytesSent 按来源类型统计的发送字节数
+	BytesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_sent_total",
+		Help:      "Total response body bytes sent by source type (url, file, webdav).",
+	}, []string{"source"})
+
+	// UpstreamLatency 上游请求从发起到传输完成的耗时
+	UpstreamLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_duration_seconds",
+		Help:      "Upstream fetch duration from request to end of body.",
+		Buckets:   []float64{0.05, 0.1, 0.5, 1, 5, 15, 60, 300, 900, 3600},
+	})
+
+	// UpstreamTTFB 上游请求首字节耗时
+	UpstreamTTFB = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_ttfb_seconds",
+		Help:      "Upstream time to first response byte.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// ActiveDownloads 进行中的下载数
+	ActiveDownloads = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_downloads",
+		Help:      "Number of in-flight downloads.",
+	})
+
+	// SignatureFailures 按原因统计的签名校验失败数
+	SignatureFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "signature_failures_total",
+		Help:      "Total rejected download signatures by reason.",
+	}, []string{"reason"})
+
+	// ExpiredLinks 访问已过期链接的次数
+	ExpiredLinks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "expired_links_total",
+		Help:      "Total requests for expired download links.",
+	})
+
+	// CacheRequests 条件请求的结果，hit 表示返回了 304
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Conditional download requests by result (hit: 304 Not Modified, miss: full response).",
+	}, []string{"result"})
+
+	// WebDavOperations 按方法与状态码统计的 WebDAV 操作数
+	WebDavOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webdav_operations_total",
+		Help:      "Total WebDAV operations by method and status.",
+	}, []string{"method", "status"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RequestsTotal,
+		RequestDuration,
+		BytesSent,
+		UpstreamLatency,
+		UpstreamTTFB,
+		ActiveDownloads,
+		SignatureFailures,
+		ExpiredLinks,
+		CacheRequests,
+		WebDavOperations,
+	)
+}
+
+// Handler 返回 /metrics 处理函数，token 不为空时需要 Bearer 认证
+func Handler(token string) http.Handler {
+	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return h
+	}
+	return common.BearerAuth(token, "metrics", h)
+}
+
+// Middleware 统计每个请求的路由、状态码与耗时
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := common.NewResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+		// ServeMux 匹配后会设置 r.Pattern，使用路由模式而非原始路径避免标签过多
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		RequestsTotal.WithLabelValues(route, Method(r.Method), strconv.Itoa(rec.Status())).Inc()
+		RequestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// 允许作为标签值的请求方法，其余方法统一为 OTHER 避免标签过多
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodDelete: true, http.MethodOptions: true, http.MethodPatch: true,
+	"PROPFIND": true, "PROPPATCH": true, "MKCOL": true, "COPY": true, "MOVE": true, "LOCK": true, "UNLOCK": true,
+}
+
+// Method 返回用作标签值的请求方法
+func Method(method string) string {
+	if knownMethods[method] {
+		return method
+	}
+	return "OTHER"
+}
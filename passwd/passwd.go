@@ -0,0 +1,194 @@
+package passwd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 支持生成的哈希算法
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// 生成 argon2id 哈希的默认参数
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 4
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// 校验 argon2id 哈希时允许的参数上限，避免哈希文件中的参数耗尽内存或 CPU
+const (
+	maxArgon2Time   = 16
+	maxArgon2Memory = 1024 * 1024 // KiB
+	maxArgon2KeyLen = 128
+)
+
+// ErrUnknownAlgorithm 不支持的哈希算法
+var ErrUnknownAlgorithm = errors.New("unknown hash algorithm")
+
+// Hash 使用指定算法生成密码哈希，cost 仅用于 bcrypt，0 表示默认值
+func Hash(password, algorithm string, cost int) (string, error) {
+	switch algorithm {
+	case Bcrypt:
+		if cost == 0 {
+			cost = bcrypt.DefaultCost
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+		return string(hash), err
+	case Argon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", ErrUnknownAlgorithm
+	}
+}
+
+// IsHash 判断是否为支持的哈希格式：bcrypt、argon2id、htpasswd 的 {SHA} 与 $apr1$
+func IsHash(s string) bool {
+	return isBcrypt(s) || strings.HasPrefix(s, "$argon2id$") || strings.HasPrefix(s, "{SHA}") ||
+		strings.HasPrefix(s, "$apr1$") || strings.HasPrefix(s, "$1$")
+}
+
+func isBcrypt(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// Validate 校验哈希格式，不是哈希时视为明文密码
+func Validate(hash string) error {
+	switch {
+	case isBcrypt(hash):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	case strings.HasPrefix(hash, "$argon2id$"):
+		_, err := parseArgon2id(hash)
+		return err
+	case strings.HasPrefix(hash, "{SHA}"):
+		if decoded, err := base64.StdEncoding.DecodeString(hash[len("{SHA}"):]); err != nil || len(decoded) != sha1.Size {
+			return errors.New("invalid {SHA} hash")
+		}
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "$1$"):
+		if strings.Count(hash, "$") != 3 {
+			return errors.New("invalid md5-crypt hash")
+		}
+	}
+	return nil
+}
+
+// Verify 校验密码是否与哈希匹配，hash 不是哈希格式时按明文比较
+// 所有比较都是常量时间
+func Verify(hash, password string) bool {
+	switch {
+	case isBcrypt(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, err := parseArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+		return subtle.ConstantTimeCompare(key, params.key) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(hash[len("{SHA}"):])) == 1
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "$1$"):
+		magic := hash[:strings.Index(hash[1:], "$")+2]
+		salt, _, ok := strings.Cut(hash[len(magic):], "$")
+		if !ok {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(md5Crypt(password, salt, magic)), []byte(hash)) == 1
+	default:
+		// 比较摘要，避免长度不同时提前返回
+		a, b := sha256.Sum256([]byte(hash)), sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+	}
+}
+
+type argon2Params struct {
+	time, memory uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+// 解析 $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key> 格式的哈希
+func parseArgon2id(hash string) (*argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errors.New("unsupported argon2id version")
+	}
+	params := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return nil, errors.New("invalid argon2id parameters")
+	}
+	// 参数为 0 时 argon2.IDKey 会 panic
+	if params.time == 0 || params.time > maxArgon2Time || params.threads == 0 ||
+		params.memory == 0 || params.memory > maxArgon2Memory {
+		return nil, fmt.Errorf("invalid argon2id parameters: need 1<=t<=%d, p>=1, 1<=m<=%d", maxArgon2Time, maxArgon2Memory)
+	}
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errors.New("invalid argon2id salt")
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 || len(params.key) > maxArgon2KeyLen {
+		return nil, errors.New("invalid argon2id key")
+	}
+	return params, nil
+}
+
+// Entry htpasswd 文件中的一个用户
+type Entry struct {
+	User string
+	Hash string
+}
+
+// LoadHtpasswd 读取 htpasswd 文件，忽略空行与 # 开头的注释
+func LoadHtpasswd(file string) ([]Entry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("read htpasswd file error: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" || !IsHash(hash) {
+			return nil, fmt.Errorf("htpasswd file %s line %d: must be <user>:<bcrypt|argon2id|{SHA}|$apr1$ hash>", file, n)
+		}
+		entries = append(entries, Entry{User: user, Hash: hash})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read htpasswd file error: %v", err)
+	}
+	return entries, nil
+}
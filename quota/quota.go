@@ -0,0 +1,213 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/webdav"
+)
+
+// ErrExceeded 写入后将超出配额
+var ErrExceeded = errors.New("quota exceeded")
+
+// Usage 一个目录已使用的字节数，只统计普通文件的大小
+// 目录与文件以存储的根目录标识加路径表示，以 / 分隔
+type Usage struct {
+	root string
+	used int64
+}
+
+// Root 返回统计的目录
+func (u *Usage) Root() string {
+	return u.root
+}
+
+// 是否包含路径 name
+func (u *Usage) covers(name string) bool {
+	return name == u.root || strings.HasPrefix(name, strings.TrimSuffix(u.root, "/")+"/")
+}
+
+// Limit 目录的配额
+type Limit struct {
+	Usage *Usage
+	Bytes int64 // 最大字节数，0 表示不限制
+}
+
+// Tracker 统计多个目录的空间占用
+// 目录在首次使用时扫描一次，之后根据写入与删除增量更新，目录之间可以相互包含
+type Tracker struct {
+	mu     sync.Mutex
+	usages map[string]*Usage
+}
+
+// NewTracker 创建Tracker
+func NewTracker() *Tracker {
+	return &Tracker{usages: make(map[string]*Usage)}
+}
+
+// Usage 返回目录的空间占用，首次使用时扫描 fs 统计，fs 为以 root 为根目录的文件系统
+func (t *Tracker) Usage(ctx context.Context, root string, fs webdav.FileSystem) (*Usage, error) {
+	t.mu.Lock()
+	u, ok := t.usages[root]
+	t.mu.Unlock()
+	if ok {
+		return u, nil
+	}
+	// 扫描期间不持有锁，不阻塞其他目录的写入
+	used, err := Size(ctx, fs, "/")
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if u, ok = t.usages[root]; !ok {
+		u = &Usage{root: root, used: used}
+		t.usages[root] = u
+	}
+	return u, nil
+}
+
+// Used 返回已使用的字节数
+func (t *Tracker) Used(u *Usage) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return u.used
+}
+
+// Available 返回所有配额中最少的剩余字节数，没有配额时返回 -1
+func (t *Tracker) Available(limits ...Limit) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	available := int64(-1)
+	for _, l := range limits {
+		if l.Bytes <= 0 {
+			continue
+		}
+		left := max(l.Bytes-l.Usage.used, 0)
+		if available < 0 || left < available {
+			available = left
+		}
+	}
+	return available
+}
+
+// Reserve 检查包含 name 的配额后增加 n 字节的占用，超出任一配额时返回 ErrExceeded
+func (t *Tracker) Reserve(name string, n int64, limits ...Limit) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n > 0 {
+		for _, l := range limits {
+			if l.Bytes > 0 && l.Usage.covers(name) && l.Usage.used+n > l.Bytes {
+				return ErrExceeded
+			}
+		}
+	}
+	t.add(name, n)
+	return nil
+}
+
+// Add 增加 name 所在目录的占用，n 为负数时减少
+func (t *Tracker) Add(name string, n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(name, n)
+}
+
+func (t *Tracker) add(name string, n int64) {
+	if n == 0 {
+		return
+	}
+	for _, u := range t.usages {
+		if u.covers(name) {
+			// 目录在统计之外被修改时可能小于 0
+			u.used = max(u.used+n, 0)
+		}
+	}
+}
+
+// Move 文件从 oldName 移动到 newName 后更新占用
+// 只有两个路径所属的统计目录不同时才调用 size 计算移动的大小
+func (t *Tracker) Move(oldName, newName string, size func() int64) {
+	t.mu.Lock()
+	var changed bool
+	for _, u := range t.usages {
+		if u.covers(oldName) != u.covers(newName) {
+			changed = true
+			break
+		}
+	}
+	t.mu.Unlock()
+	if !changed {
+		return
+	}
+	n := size()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, u := range t.usages {
+		switch from, to := u.covers(oldName), u.covers(newName); {
+		case from && !to:
+			u.used = max(u.used-n, 0)
+		case to && !from:
+			u.used += n
+		}
+	}
+}
+
+// Retain 只保留 roots 中的目录，其余目录不再统计
+func (t *Tracker) Retain(roots []string) {
+	keep := make(map[string]bool, len(roots))
+	for _, root := range roots {
+		keep[root] = true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for root := range t.usages {
+		if !keep[root] {
+			delete(t.usages, root)
+		}
+	}
+}
+
+// Size 返回文件或目录下所有普通文件的总大小，不存在时返回 0
+func Size(ctx context.Context, fs webdav.FileSystem, name string) (int64, error) {
+	info, err := fs.Stat(ctx, name)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		if info.Mode().IsRegular() {
+			return info.Size(), nil
+		}
+		return 0, nil
+	}
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	children, err := f.Readdir(-1)
+	_ = f.Close()
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, child := range children {
+		if child.IsDir() {
+			n, err := Size(ctx, fs, path.Join(name, child.Name()))
+			if err != nil {
+				return 0, err
+			}
+			size += n
+		} else if child.Mode().IsRegular() {
+			size += child.Size()
+		}
+	}
+	return size, nil
+}
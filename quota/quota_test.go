@@ -0,0 +1,145 @@
+package quota
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func writeFile(t *testing.T, fs webdav.FileSystem, name string, size int) {
+	t.Helper()
+	f, err := fs.OpenFile(context.Background(), name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(make([]byte, size)); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSize(t *testing.T) {
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	if err := fs.Mkdir(ctx, "/a", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir(ctx, "/a/b", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/x", 1)
+	writeFile(t, fs, "/a/y", 10)
+	writeFile(t, fs, "/a/b/z", 100)
+
+	for name, want := range map[string]int64{"/": 111, "/a": 110, "/a/b/z": 100, "/missing": 0} {
+		if got, err := Size(ctx, fs, name); err != nil || got != want {
+			t.Errorf("Size(%s) = %d, %v, want %d", name, got, err, want)
+		}
+	}
+}
+
+// 全局配额统计 /root，用户配额统计 /root/alice，写入同时计入两者
+func TestTrackerNestedLimits(t *testing.T) {
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	writeFile(t, fs, "/existing", 30)
+
+	tracker := NewTracker()
+	total, err := tracker.Usage(ctx, "/root", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := tracker.Usage(ctx, "/root/alice", webdav.NewMemFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 再次获取时不重新扫描
+	if again, _ := tracker.Usage(ctx, "/root", webdav.NewMemFS()); again != total || tracker.Used(total) != 30 {
+		t.Fatalf("usage of /root = %d, want the scanned 30", tracker.Used(again))
+	}
+	limits := []Limit{{Usage: total, Bytes: 100}, {Usage: user, Bytes: 50}}
+	if got := tracker.Available(limits...); got != 50 {
+		t.Errorf("available = %d, want 50", got)
+	}
+
+	if err = tracker.Reserve("/root/alice/a.txt", 40, limits...); err != nil {
+		t.Fatal(err)
+	}
+	if tracker.Used(total) != 70 || tracker.Used(user) != 40 {
+		t.Errorf("used = %d, %d, want 70, 40", tracker.Used(total), tracker.Used(user))
+	}
+	// 超出用户配额时不增加占用
+	if err = tracker.Reserve("/root/alice/b.txt", 20, limits...); err != ErrExceeded {
+		t.Errorf("reserve over user quota error = %v, want ErrExceeded", err)
+	}
+	// 其他用户只受全局配额限制
+	if err = tracker.Reserve("/root/bob/b.txt", 31, limits...); err != ErrExceeded {
+		t.Errorf("reserve over total quota error = %v, want ErrExceeded", err)
+	}
+	if err = tracker.Reserve("/root/bob/b.txt", 30, limits...); err != nil {
+		t.Errorf("reserve within total quota: %v", err)
+	}
+	if tracker.Used(total) != 100 || tracker.Used(user) != 40 {
+		t.Errorf("used = %d, %d, want 100, 40", tracker.Used(total), tracker.Used(user))
+	}
+	if got := tracker.Available(limits...); got != 0 {
+		t.Errorf("available = %d, want 0", got)
+	}
+
+	// 释放占用，不会小于 0
+	tracker.Add("/root/alice/a.txt", -1000)
+	if tracker.Used(total) != 0 || tracker.Used(user) != 0 {
+		t.Errorf("used after remove = %d, %d, want 0, 0", tracker.Used(total), tracker.Used(user))
+	}
+	// 没有配额时不限制
+	if got := tracker.Available(Limit{Usage: total}); got != -1 {
+		t.Errorf("available without quota = %d, want -1", got)
+	}
+}
+
+func TestTrackerMove(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewTracker()
+	alice, _ := tracker.Usage(ctx, "/root/alice", webdav.NewMemFS())
+	bob, _ := tracker.Usage(ctx, "/root/bob", webdav.NewMemFS())
+	tracker.Add("/root/alice/a.txt", 10)
+
+	// 同一目录内移动不计算大小
+	tracker.Move("/root/alice/a.txt", "/root/alice/b.txt", func() int64 {
+		t.Error("size called for a move inside the same usage")
+		return 0
+	})
+	tracker.Move("/root/alice/b.txt", "/root/bob/b.txt", func() int64 { return 10 })
+	if tracker.Used(alice) != 0 || tracker.Used(bob) != 10 {
+		t.Errorf("used after move = %d, %d, want 0, 10", tracker.Used(alice), tracker.Used(bob))
+	}
+
+	// 不再统计的目录被丢弃，再次使用时重新扫描
+	tracker.Retain([]string{"/root/alice"})
+	fs := webdav.NewMemFS()
+	writeFile(t, fs, "/c.txt", 5)
+	if again, _ := tracker.Usage(ctx, "/root/bob", fs); again == bob || tracker.Used(again) != 5 {
+		t.Errorf("usage of /root/bob after retain = %d, want rescanned 5", tracker.Used(again))
+	}
+	if again, _ := tracker.Usage(ctx, "/root/alice", fs); again != alice {
+		t.Error("retained usage was rescanned")
+	}
+}
+
+func TestUsageCovers(t *testing.T) {
+	u := &Usage{root: "/root/alice"}
+	for name, want := range map[string]bool{
+		"/root/alice":       true,
+		"/root/alice/a.txt": true,
+		"/root/alicex":      false,
+		"/root":             false,
+	} {
+		if got := u.covers(name); got != want {
+			t.Errorf("covers(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,706 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// 读取错误响应的最大字节数
+const maxS3ErrorBody = 4096
+
+// 单次上传或复制对象的最大字节数，超过时分段上传或复制
+const maxS3SingleSize = 5 << 30
+
+// 分段上传的默认分段大小与最大分段数
+const (
+	s3PartSize    = 64 << 20
+	maxS3PartsNum = 10000
+)
+
+// S3Options S3 兼容存储的配置
+type S3Options struct {
+	Endpoint  string // 服务地址，如 https://s3.us-east-1.amazonaws.com
+	Region    string // 区域，为空时为 us-east-1
+	Bucket    string
+	Prefix    string // 对象键前缀，文件保存在该前缀下
+	AccessKey string // 为空时发送匿名请求
+	SecretKey string
+	PathStyle bool // 使用 endpoint/bucket 形式的地址，MinIO 等通常需要开启
+}
+
+// S3 S3 兼容的对象存储
+// 目录为以 / 结尾的空对象，也可以只由对象键的前缀隐含
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	prefix   string // 不以 / 开头或结尾
+	client   *http.Client
+
+	maxSingle int64 // 单次上传或复制的最大字节数
+	partSize  int64 // 分段上传的最小分段大小
+}
+
+// NewS3 创建S3存储，不会请求服务端
+func NewS3(opts S3Options) (*S3, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", opts.Endpoint)
+	}
+	if opts.Bucket == "" {
+		return nil, errors.New("s3 bucket must be set")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	return &S3{
+		opts:     opts,
+		endpoint: endpoint,
+		prefix:   strings.Trim(path.Clean("/"+opts.Prefix), "/"),
+		client:   &http.Client{},
+
+		maxSingle: maxS3SingleSize,
+		partSize:  s3PartSize,
+	}, nil
+}
+
+// Root 返回 s3://bucket/prefix
+func (s *S3) Root() string {
+	return strings.TrimSuffix("s3://"+s.opts.Bucket+"/"+s.prefix, "/")
+}
+
+// Sub 返回子目录的存储
+func (s *S3) Sub(ctx context.Context, dir string) (Storage, error) {
+	return newSubStorage(ctx, s, dir)
+}
+
+// 路径对应的对象键，根目录为前缀本身
+func (s *S3) key(name string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, path.Clean("/"+name)), "/")
+}
+
+// 目录下对象键的前缀
+func dirPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+func (s *S3) Mkdir(ctx context.Context, name string, _ os.FileMode) error {
+	name = path.Clean("/" + name)
+	if _, err := s.Stat(ctx, name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if err := s.checkParent(ctx, "mkdir", name); err != nil {
+		return err
+	}
+	return s.put(ctx, dirPrefix(s.key(name)), nil)
+}
+
+func (s *S3) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	name = path.Clean("/" + name)
+	info, err := s.Stat(ctx, name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	exists := err == nil
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		if !exists {
+			return nil, err
+		}
+		if info.IsDir() {
+			return &s3Dir{s: s, ctx: ctx, name: name, info: info}, nil
+		}
+		return &s3File{s: s, ctx: ctx, key: s.key(name), info: info}, nil
+	}
+
+	// 写入时先写到临时文件，关闭时上传
+	switch {
+	case exists && info.IsDir():
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	case exists && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case !exists:
+		if err = s.checkParent(ctx, "open", name); err != nil {
+			return nil, err
+		}
+	}
+	tmp, err := os.CreateTemp("", "fda-s3-*")
+	if err != nil {
+		return nil, err
+	}
+	f := &s3WriteFile{File: tmp, s: s, ctx: ctx, key: s.key(name), name: path.Base(name), append: flag&os.O_APPEND != 0}
+	if exists && flag&os.O_TRUNC == 0 {
+		// 保留原内容，之后的写入修改原文件
+		if err = s.download(ctx, f.key, tmp); err == nil {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			f.discard()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (s *S3) RemoveAll(ctx context.Context, name string) error {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		// 与 webdav.Dir 一致，不允许删除根目录
+		return os.ErrInvalid
+	}
+	key := s.key(name)
+	if err := s.delete(ctx, key); err != nil {
+		return err
+	}
+	return s.walk(ctx, dirPrefix(key), func(object s3Object) error {
+		return s.delete(ctx, object.Key)
+	})
+}
+
+func (s *S3) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = path.Clean("/"+oldName), path.Clean("/"+newName)
+	if oldName == "/" || newName == "/" {
+		return os.ErrInvalid
+	}
+	info, err := s.Stat(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	if err = s.checkParent(ctx, "rename", newName); err != nil {
+		return err
+	}
+	oldKey, newKey := s.key(oldName), s.key(newName)
+	if !info.IsDir() {
+		if err = s.copy(ctx, oldKey, newKey, info.Size()); err != nil {
+			return err
+		}
+		return s.delete(ctx, oldKey)
+	}
+	// 对象存储没有目录，逐个复制后删除
+	return s.walk(ctx, dirPrefix(oldKey), func(object s3Object) error {
+		target := dirPrefix(newKey) + strings.TrimPrefix(object.Key, dirPrefix(oldKey))
+		if err := s.copy(ctx, object.Key, target, object.Size); err != nil {
+			return err
+		}
+		return s.delete(ctx, object.Key)
+	})
+}
+
+func (s *S3) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = path.Clean("/" + name)
+	key := s.key(name)
+	if name == "/" {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err == nil {
+		_ = resp.Body.Close()
+		return objectInfo(path.Base(name), resp), nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	// 存在以 key/ 开头的对象时为目录
+	list, err := s.list(ctx, dirPrefix(key), "/", "", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Contents) == 0 && len(list.CommonPrefixes) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return &fileInfo{name: path.Base(name), dir: true}, nil
+}
+
+// 上级目录不存在时返回 os.ErrNotExist
+func (s *S3) checkParent(ctx context.Context, op, name string) error {
+	parent := path.Dir(name)
+	if parent == "/" {
+		return nil
+	}
+	if info, err := s.Stat(ctx, parent); err != nil || !info.IsDir() {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+// 发送请求，响应不是 2xx 时返回错误，404 时错误为 os.ErrNotExist
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader) (*http.Response, error) {
+	u := *s.endpoint
+	objectPath := "/" + key
+	if s.opts.PathStyle {
+		objectPath = "/" + s.opts.Bucket + objectPath
+	} else {
+		u.Host = s.opts.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + objectPath
+	u.RawPath = s3Escape(u.Path, true)
+	// 签名要求查询参数按名称排序
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	slices.Sort(params)
+	u.RawQuery = strings.Join(params, "&")
+
+	// S3 不支持分块传输，需要设置请求体长度，长度为 0 时 http.Client 会视为未知长度，改为 http.NoBody
+	size := int64(-1)
+	switch b := body.(type) {
+	case *os.File:
+		if info, err := b.Stat(); err == nil {
+			size = info.Size()
+		}
+	case *io.SectionReader:
+		size = b.Size()
+	}
+	if size == 0 {
+		body = http.NoBody
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if size > 0 {
+		req.ContentLength = size
+	}
+	if s.opts.AccessKey != "" {
+		signV4(req, s.opts.AccessKey, s.opts.SecretKey, s.opts.Region, time.Now())
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxS3ErrorBody))
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	_ = xml.Unmarshal(msg, &s3Err)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &os.PathError{Op: strings.ToLower(method), Path: key, Err: os.ErrNotExist}
+	}
+	return nil, fmt.Errorf("s3 %s %s: %s %s %s", method, key, resp.Status, s3Err.Code, s3Err.Message)
+}
+
+func (s *S3) put(ctx context.Context, key string, body io.Reader) error {
+	if body == nil {
+		body = http.NoBody
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, nil, body)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// 复制对象，size 为源对象的大小，超过单次复制的上限时分段复制
+func (s *S3) copy(ctx context.Context, src, dst string, size int64) error {
+	source := s3Escape("/"+s.opts.Bucket+"/"+src, true)
+	if size > s.maxSingle {
+		return s.multipart(ctx, dst, size, func(query url.Values, offset, n int64) (string, error) {
+			header := http.Header{
+				"X-Amz-Copy-Source":       {source},
+				"X-Amz-Copy-Source-Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+n-1)},
+			}
+			var result struct {
+				ETag string `xml:"ETag"`
+			}
+			err := s.doXML(ctx, http.MethodPut, dst, query, header, http.NoBody, &result)
+			return result.ETag, err
+		})
+	}
+	return s.doXML(ctx, http.MethodPut, dst, nil, http.Header{"X-Amz-Copy-Source": {source}}, http.NoBody, nil)
+}
+
+// 上传对象，size 超过单次上传的上限时分段上传
+func (s *S3) upload(ctx context.Context, key string, body io.ReaderAt, size int64) error {
+	if size <= s.maxSingle {
+		return s.put(ctx, key, io.NewSectionReader(body, 0, size))
+	}
+	return s.multipart(ctx, key, size, func(query url.Values, offset, n int64) (string, error) {
+		resp, err := s.do(ctx, http.MethodPut, key, query, nil, io.NewSectionReader(body, offset, n))
+		if err != nil {
+			return "", err
+		}
+		_ = resp.Body.Close()
+		return resp.Header.Get("ETag"), nil
+	})
+}
+
+// 分段上传 size 字节到 key，part 上传 [offset, offset+n) 的分段并返回分段的 ETag
+// query 为分段的 partNumber 与 uploadId，任一步骤失败时取消上传
+func (s *S3) multipart(ctx context.Context, key string, size int64, part func(query url.Values, offset, n int64) (string, error)) error {
+	var created struct {
+		UploadId string `xml:"UploadId"`
+	}
+	if err := s.doXML(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, http.NoBody, &created); err != nil {
+		return err
+	}
+	if created.UploadId == "" {
+		return fmt.Errorf("s3 create multipart upload %s: missing upload id", key)
+	}
+	uploadId := url.Values{"uploadId": {created.UploadId}}
+
+	type completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var complete struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}
+	// 分段数不能超过上限
+	partSize := max(s.partSize, (size+maxS3PartsNum-1)/maxS3PartsNum)
+	err := func() error {
+		for offset := int64(0); offset < size; offset += partSize {
+			number := len(complete.Parts) + 1
+			query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": uploadId["uploadId"]}
+			etag, err := part(query, offset, min(partSize, size-offset))
+			if err != nil {
+				return err
+			}
+			complete.Parts = append(complete.Parts, completedPart{PartNumber: number, ETag: etag})
+		}
+		body, err := xml.Marshal(complete)
+		if err != nil {
+			return err
+		}
+		return s.doXML(ctx, http.MethodPost, key, uploadId, nil, bytes.NewReader(body), nil)
+	}()
+	if err != nil {
+		// 请求被取消时仍需取消上传，避免未完成的分段继续占用空间
+		if resp, abortErr := s.do(context.WithoutCancel(ctx), http.MethodDelete, key, uploadId, nil, nil); abortErr == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// 发送请求并解析 XML 响应体到 result，result 为空时只检查错误
+// 复制与完成分段上传失败时也可能返回 200，错误在响应体中
+func (s *S3) doXML(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, result any) error {
+	resp, err := s.do(ctx, method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxS3ErrorBody))
+	if err != nil {
+		return err
+	}
+	var s3Err struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+	}
+	if xml.Unmarshal(data, &s3Err) == nil && s3Err.XMLName.Local == "Error" {
+		return fmt.Errorf("s3 %s %s: %s", method, key, s3Err.Code)
+	}
+	if result != nil {
+		return xml.Unmarshal(data, result)
+	}
+	return nil
+}
+
+// 删除对象，不存在时不返回错误
+func (s *S3) delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// 下载对象写入 w
+func (s *S3) download(ctx context.Context, key string, w io.Writer) error {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// 列表中的对象
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// ListObjectsV2 的响应
+type s3ListResult struct {
+	Contents       []s3Object `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// 列出前缀下的对象，delimiter 为 / 时只列出一级
+func (s *S3) list(ctx context.Context, prefix, delimiter, token string, maxKeys int) (*s3ListResult, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+	resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result s3ListResult
+	if err = xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("s3 list %s: %w", prefix, err)
+	}
+	return &result, nil
+}
+
+// 遍历前缀下的所有对象
+func (s *S3) walk(ctx context.Context, prefix string, fn func(object s3Object) error) error {
+	var token string
+	for {
+		list, err := s.list(ctx, prefix, "", token, 0)
+		if err != nil {
+			return err
+		}
+		for _, object := range list.Contents {
+			if err = fn(object); err != nil {
+				return err
+			}
+		}
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			return nil
+		}
+		token = list.NextContinuationToken
+	}
+}
+
+// 文件或目录的信息
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() any           { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// 根据 HEAD 或 GET 的响应头生成文件信息
+func objectInfo(name string, resp *http.Response) *fileInfo {
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &fileInfo{name: name, size: resp.ContentLength, modTime: modTime}
+}
+
+// 只读的对象，按需使用 Range 请求读取
+// 请求使用打开文件时的 ctx，客户端断开后不再读取
+type s3File struct {
+	s      *S3
+	ctx    context.Context
+	key    string
+	info   os.FileInfo
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", f.offset)}}
+		resp, err := f.s.do(f.ctx, http.MethodGet, f.key, nil, header, nil)
+		if err != nil {
+			return 0, err
+		}
+		f.body = resp.Body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != f.offset && f.body != nil {
+		_ = f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+func (f *s3File) Write([]byte) (int, error)          { return 0, os.ErrPermission }
+func (f *s3File) Readdir(int) ([]os.FileInfo, error) { return nil, errors.New("not a directory") }
+func (f *s3File) Stat() (os.FileInfo, error)         { return f.info, nil }
+
+// 目录，Readdir 时列出一级对象
+type s3Dir struct {
+	s       *S3
+	ctx     context.Context
+	name    string
+	info    os.FileInfo
+	entries []os.FileInfo
+	listed  bool
+}
+
+func (d *s3Dir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.listed {
+		if err := d.load(); err != nil {
+			return nil, err
+		}
+		d.listed = true
+	}
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *s3Dir) load() error {
+	prefix := dirPrefix(d.s.key(d.name))
+	var token string
+	for {
+		list, err := d.s.list(d.ctx, prefix, "/", token, 0)
+		if err != nil {
+			return err
+		}
+		for _, p := range list.CommonPrefixes {
+			d.entries = append(d.entries, &fileInfo{name: path.Base(p.Prefix), dir: true})
+		}
+		for _, object := range list.Contents {
+			if object.Key == prefix {
+				// 目录本身
+				continue
+			}
+			d.entries = append(d.entries, &fileInfo{
+				name:    strings.TrimPrefix(object.Key, prefix),
+				size:    object.Size,
+				modTime: object.LastModified,
+			})
+		}
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			return nil
+		}
+		token = list.NextContinuationToken
+	}
+}
+
+func (d *s3Dir) Read([]byte) (int, error)       { return 0, errors.New("is a directory") }
+func (d *s3Dir) Write([]byte) (int, error)      { return 0, errors.New("is a directory") }
+func (d *s3Dir) Seek(int64, int) (int64, error) { return 0, nil }
+func (d *s3Dir) Stat() (os.FileInfo, error)     { return d.info, nil }
+func (d *s3Dir) Close() error                   { return nil }
+
+// 写入的文件，内容保存在临时文件中，关闭时上传
+type s3WriteFile struct {
+	*os.File
+	s      *S3
+	ctx    context.Context
+	key    string
+	name   string
+	append bool
+}
+
+func (f *s3WriteFile) Write(p []byte) (int, error) {
+	if f.append {
+		if _, err := f.File.Seek(0, io.SeekEnd); err != nil {
+			return 0, err
+		}
+	}
+	return f.File.Write(p)
+}
+
+func (f *s3WriteFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+func (f *s3WriteFile) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: f.name, size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+func (f *s3WriteFile) Close() error {
+	defer f.discard()
+	info, err := f.File.Stat()
+	if err != nil {
+		return err
+	}
+	return f.s.upload(f.ctx, f.key, f.File, info.Size())
+}
+
+// 删除临时文件
+func (f *s3WriteFile) discard() {
+	_ = f.File.Close()
+	_ = os.Remove(f.File.Name())
+}
@@ -0,0 +1,554 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "eu-west-1"
+	testBucket    = "files"
+)
+
+// 内存中的 S3 服务端，校验每个请求的签名，列表每页最多返回 pageSize 个条目
+type fakeS3 struct {
+	t        *testing.T
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	ranges  []string // 收到的 Range 请求头
+	lists   int      // 收到的列表请求数
+	// 未完成的分段上传，uploadId 到对象键与各分段内容
+	uploads    map[string]*fakeUpload
+	nextUpload int
+	parts      int // 收到的分段数
+}
+
+type fakeUpload struct {
+	key   string
+	parts map[int][]byte
+}
+
+func newFakeS3(t *testing.T, pageSize int) (*fakeS3, *S3) {
+	f := &fakeS3{t: t, pageSize: pageSize, objects: make(map[string][]byte), uploads: make(map[string]*fakeUpload)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	s, err := NewS3(S3Options{
+		Endpoint:  server.URL,
+		Region:    testRegion,
+		Bucket:    testBucket,
+		Prefix:    "data",
+		AccessKey: testAccessKey,
+		SecretKey: testSecretKey,
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, s
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := verifySignature(r); err != nil {
+		f.t.Errorf("%s %s: %v", r.Method, r.URL, err)
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+testBucket+"/")
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	query := r.URL.Query()
+	// S3 不接受分块传输的请求体
+	if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.ContentLength < 0 {
+		f.t.Errorf("%s %s: missing Content-Length", r.Method, r.URL)
+		http.Error(w, "<Error><Code>MissingContentLength</Code></Error>", http.StatusLengthRequired)
+		return
+	}
+	switch {
+	case query.Has("uploads") || query.Has("uploadId"):
+		f.multipart(w, r, key)
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.lists++
+		f.list(w, r)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Last-Modified", time.Unix(0, 0).UTC().Format(http.TimeFormat))
+		if rng := r.Header.Get("Range"); rng != "" {
+			f.ranges = append(f.ranges, rng)
+			start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			if err != nil || start >= len(data) {
+				http.Error(w, "<Error><Code>InvalidRange</Code></Error>", http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)-start))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(data[start:])
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case r.Method == http.MethodPut:
+		if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+			// 复制源为编码后的 /bucket/key
+			src, _ = url.PathUnescape(src)
+			data, ok := f.objects[strings.TrimPrefix(src, "/"+testBucket+"/")]
+			if !ok {
+				http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+				return
+			}
+			f.objects[key] = data
+			_, _ = io.WriteString(w, "<CopyObjectResult></CopyObjectResult>")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "<Error><Code>NotImplemented</Code></Error>", http.StatusNotImplemented)
+	}
+}
+
+// 分段上传的创建、上传分段、复制分段、完成与取消
+func (f *fakeS3) multipart(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	if r.Method == http.MethodPost && query.Has("uploads") {
+		f.nextUpload++
+		id := strconv.Itoa(f.nextUpload)
+		f.uploads[id] = &fakeUpload{key: key, parts: make(map[int][]byte)}
+		_, _ = fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+		return
+	}
+	upload, ok := f.uploads[query.Get("uploadId")]
+	if !ok || upload.key != key {
+		http.Error(w, "<Error><Code>NoSuchUpload</Code></Error>", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		number, err := strconv.Atoi(query.Get("partNumber"))
+		if err != nil || number < 1 {
+			http.Error(w, "<Error><Code>InvalidArgument</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		f.parts++
+		etag := fmt.Sprintf("%q", "part"+strconv.Itoa(number))
+		src := r.Header.Get("X-Amz-Copy-Source")
+		if src == "" {
+			upload.parts[number], _ = io.ReadAll(r.Body)
+			w.Header().Set("ETag", etag)
+			return
+		}
+		src, _ = url.PathUnescape(src)
+		data, ok := f.objects[strings.TrimPrefix(src, "/"+testBucket+"/")]
+		var start, end int
+		if _, err = fmt.Sscanf(r.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &start, &end); !ok || err != nil || end >= len(data) {
+			http.Error(w, "<Error><Code>InvalidRange</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		upload.parts[number] = data[start : end+1]
+		_, _ = fmt.Fprintf(w, "<CopyPartResult><ETag>%s</ETag></CopyPartResult>", html.EscapeString(etag))
+	case http.MethodPost:
+		var complete struct {
+			Parts []struct {
+				PartNumber int    `xml:"PartNumber"`
+				ETag       string `xml:"ETag"`
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil || len(complete.Parts) == 0 {
+			http.Error(w, "<Error><Code>MalformedXML</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		var data []byte
+		for i, part := range complete.Parts {
+			content, ok := upload.parts[part.PartNumber]
+			if !ok || part.PartNumber != i+1 || part.ETag != fmt.Sprintf("%q", "part"+strconv.Itoa(part.PartNumber)) {
+				// 与 S3 相同，完成失败时返回 200 与错误响应体
+				_, _ = io.WriteString(w, "<Error><Code>InvalidPart</Code></Error>")
+				return
+			}
+			data = append(data, content...)
+		}
+		f.objects[key] = data
+		delete(f.uploads, query.Get("uploadId"))
+		_, _ = io.WriteString(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case http.MethodDelete:
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "<Error><Code>NotImplemented</Code></Error>", http.StatusNotImplemented)
+	}
+}
+
+// 按名称排序的所有对象键
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Sorted(maps.Keys(f.objects))
+}
+
+// 返回并清空收到的 Range 请求头与列表请求数
+func (f *fakeS3) requests() (ranges []string, lists int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ranges, lists = f.ranges, f.lists
+	f.ranges, f.lists = nil, 0
+	return ranges, lists
+}
+
+// ListObjectsV2，continuation-token 为上一页最后一个条目
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix, delimiter, token := query.Get("prefix"), query.Get("delimiter"), query.Get("continuation-token")
+	maxKeys := f.pageSize
+	if n, err := strconv.Atoi(query.Get("max-keys")); err == nil && n < maxKeys {
+		maxKeys = n
+	}
+	// 按名称排序的对象与公共前缀，公共前缀只出现一次
+	type entry struct {
+		name   string
+		prefix bool
+	}
+	var entries []entry
+	seen := make(map[string]bool)
+	for key := range f.objects {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			if p := prefix + rest[:i+1]; !seen[p] {
+				seen[p] = true
+				entries = append(entries, entry{name: p, prefix: true})
+			}
+			continue
+		}
+		entries = append(entries, entry{name: key})
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.name, b.name) })
+	for len(entries) > 0 && token != "" && entries[0].name <= token {
+		entries = entries[1:]
+	}
+
+	var result s3ListResult
+	for i, e := range entries {
+		if i == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = entries[i-1].name
+			break
+		}
+		if e.prefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, struct {
+				Prefix string `xml:"Prefix"`
+			}{e.name})
+		} else {
+			result.Contents = append(result.Contents, s3Object{Key: e.name, Size: int64(len(f.objects[e.name]))})
+		}
+	}
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		s3ListResult
+	}{s3ListResult: result})
+}
+
+// 按 Authorization 头中的签名头重新计算签名，与 S3 服务端的校验方式相同
+func verifySignature(r *http.Request) error {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errors.New("missing AWS4-HMAC-SHA256 authorization")
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(auth, ", ") {
+		name, value, _ := strings.Cut(field, "=")
+		fields[name] = value
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len("20060102T150405Z") {
+		return fmt.Errorf("invalid X-Amz-Date %q", amzDate)
+	}
+	scope := amzDate[:8] + "/" + testRegion + "/s3/aws4_request"
+	if fields["Credential"] != testAccessKey+"/"+scope {
+		return fmt.Errorf("unexpected credential %q", fields["Credential"])
+	}
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if !slices.Contains(signed, "host") || !slices.Contains(signed, "x-amz-date") || !slices.Contains(signed, "x-amz-content-sha256") {
+		return fmt.Errorf("host and x-amz headers must be signed, got %q", fields["SignedHeaders"])
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+
+	// 查询参数按编码后的名称排序
+	var params []string
+	for name, values := range r.URL.Query() {
+		for _, value := range values {
+			params = append(params, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	slices.Sort(params)
+	canonical := strings.Join([]string{
+		r.Method,
+		s3Escape(r.URL.Path, true),
+		strings.Join(params, "&"),
+		headers.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range []string{amzDate[:8], testRegion, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	hash := sha256.Sum256([]byte(canonical))
+	want := hex.EncodeToString(hmacSHA256(key, "AWS4-HMAC-SHA256\n"+amzDate+"\n"+scope+"\n"+hex.EncodeToString(hash[:])))
+	if fields["Signature"] != want {
+		return fmt.Errorf("signature mismatch, canonical request:\n%s", canonical)
+	}
+	return nil
+}
+
+func writeFile(t *testing.T, fs Storage, name, content string) {
+	t.Helper()
+	f, err := fs.OpenFile(context.Background(), name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	if _, err = io.WriteString(f, content); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatalf("close %s: %v", name, err)
+	}
+}
+
+func TestS3ReadWrite(t *testing.T) {
+	fake, s := newFakeS3(t, 1000)
+	ctx := context.Background()
+
+	if err := s.Mkdir(ctx, "/docs", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// 名称中的空格与非 ASCII 字符需要按 S3 规则编码后签名
+	name := "/docs/hello world+中文.txt"
+	writeFile(t, s, name, "0123456789")
+	if keys := fake.keys(); !slices.Contains(keys, "data/docs/hello world+中文.txt") {
+		t.Fatalf("object not stored under the prefix, got %q", keys)
+	}
+
+	info, err := s.Stat(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 10 || info.IsDir() {
+		t.Fatalf("stat = size %d dir %v, want size 10 file", info.Size(), info.IsDir())
+	}
+	if info, err = s.Stat(ctx, "/docs"); err != nil || !info.IsDir() {
+		t.Fatalf("stat /docs = %v, %v, want dir", info, err)
+	}
+	if _, err = s.Stat(ctx, "/missing"); !os.IsNotExist(err) {
+		t.Fatalf("stat /missing error = %v, want not exist", err)
+	}
+
+	f, err := s.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, _ = fake.requests()
+	if _, err = f.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	if _, err = io.ReadFull(f, buf); err != nil || string(buf) != "456" {
+		t.Fatalf("read after seek = %q, %v, want 456", buf, err)
+	}
+	// 继续读取时复用同一个响应，向后跳转时重新请求
+	if _, err = io.ReadFull(f, buf); err != nil || string(buf) != "789" {
+		t.Fatalf("read next = %q, %v, want 789", buf, err)
+	}
+	if _, err = f.Seek(-8, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(f)
+	if err != nil || string(rest) != "23456789" {
+		t.Fatalf("read from end = %q, %v, want 23456789", rest, err)
+	}
+	if ranges, _ := fake.requests(); !slices.Equal(ranges, []string{"bytes=4-", "bytes=2-"}) {
+		t.Fatalf("range requests = %q, want [bytes=4- bytes=2-]", ranges)
+	}
+
+	if err = s.Rename(ctx, name, "/docs/renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Stat(ctx, name); !os.IsNotExist(err) {
+		t.Fatalf("stat after rename error = %v, want not exist", err)
+	}
+	if err = s.RemoveAll(ctx, "/docs"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := fake.keys(); len(keys) != 0 {
+		t.Fatalf("objects after remove = %q, want none", keys)
+	}
+}
+
+func TestS3ReaddirPagination(t *testing.T) {
+	fake, s := newFakeS3(t, 2)
+	ctx := context.Background()
+
+	var want []string
+	for i := range 5 {
+		name := fmt.Sprintf("file%d.txt", i)
+		writeFile(t, s, "/"+name, name)
+		want = append(want, name)
+	}
+	for _, dir := range []string{"a", "b"} {
+		if err := s.Mkdir(ctx, "/"+dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, s, "/"+dir+"/nested.txt", "nested")
+		want = append(want, dir)
+	}
+
+	f, err := s.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, _ = fake.requests()
+	infos, err := f.Readdir(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, info := range infos {
+		got = append(got, info.Name())
+		if wantDir := len(info.Name()) == 1; info.IsDir() != wantDir {
+			t.Errorf("%s: dir = %v, want %v", info.Name(), info.IsDir(), wantDir)
+		}
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("readdir = %q, want %q", got, want)
+	}
+	// 7 个条目每页 2 个
+	if _, lists := fake.requests(); lists != 4 {
+		t.Fatalf("list requests = %d, want 4", lists)
+	}
+
+	// 遍历同样需要翻页，删除目录下的所有对象
+	if err = s.RemoveAll(ctx, "/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Stat(ctx, "/a/nested.txt"); !os.IsNotExist(err) {
+		t.Fatalf("stat after remove error = %v, want not exist", err)
+	}
+}
+
+func TestS3FileUsesOpenContext(t *testing.T) {
+	_, s := newFakeS3(t, 1000)
+	writeFile(t, s, "/a.txt", "hello")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := s.OpenFile(ctx, "/a.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// 请求结束后不再读取对象
+	cancel()
+	if _, err = f.Read(make([]byte, 5)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("read after cancel error = %v, want context.Canceled", err)
+	}
+}
+
+// 超过单次上传与复制的上限时分段上传与复制
+func TestS3Multipart(t *testing.T) {
+	fake, s := newFakeS3(t, 1000)
+	s.maxSingle, s.partSize = 10, 4
+	ctx := context.Background()
+
+	writeFile(t, s, "/small.txt", "0123456789")
+	writeFile(t, s, "/empty.txt", "")
+	if fake.parts != 0 {
+		t.Fatalf("parts = %d, want single uploads", fake.parts)
+	}
+	content := "0123456789abcdefghij"
+	writeFile(t, s, "/big.txt", content)
+	// 20 字节每段 4 字节
+	if fake.parts != 5 {
+		t.Fatalf("upload parts = %d, want 5", fake.parts)
+	}
+	if err := s.Rename(ctx, "/big.txt", "/moved.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.parts != 10 {
+		t.Fatalf("copy parts = %d, want 5", fake.parts-5)
+	}
+	f, err := s.OpenFile(ctx, "/moved.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got, err := io.ReadAll(f); err != nil || string(got) != content {
+		t.Fatalf("moved content = %q, %v, want %q", got, err, content)
+	}
+	if keys := fake.keys(); !slices.Equal(keys, []string{"data/empty.txt", "data/moved.txt", "data/small.txt"}) {
+		t.Fatalf("keys = %q", keys)
+	}
+	if len(fake.uploads) != 0 {
+		t.Fatalf("unfinished uploads = %d, want 0", len(fake.uploads))
+	}
+
+	// 分段数不超过上限
+	s.partSize = 1
+	if err = s.multipart(ctx, "data/parts", maxS3PartsNum*2+1, func(query url.Values, offset, n int64) (string, error) {
+		if number, _ := strconv.Atoi(query.Get("partNumber")); number > maxS3PartsNum || n != 3 && offset+n != maxS3PartsNum*2+1 {
+			return "", fmt.Errorf("part %d: offset %d size %d", number, offset, n)
+		}
+		return "", errors.New("stop")
+	}); err == nil || err.Error() != "stop" {
+		t.Fatalf("multipart error = %v, want stop", err)
+	}
+	// 失败时取消上传
+	if len(fake.uploads) != 0 {
+		t.Fatalf("uploads after failure = %d, want aborted", len(fake.uploads))
+	}
+}
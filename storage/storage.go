@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// 存储类型
+const (
+	TypeLocal  = "local"
+	TypeS3     = "s3"
+	TypeMemory = "memory"
+)
+
+// Storage 文件存储，file:// 下载与 WebDAV 共用
+// 路径以 / 分隔，相对于存储的根目录
+type Storage interface {
+	webdav.FileSystem
+	// Root 返回根目录的唯一标识，用于配额统计、回收站与锁
+	// 子目录的标识为根目录的标识加上 /子目录
+	Root() string
+	// Sub 返回以 dir 为根目录的存储，dir 不存在时创建
+	Sub(ctx context.Context, dir string) (Storage, error)
+}
+
+// Options 存储配置
+type Options struct {
+	Type string // local、s3 或 memory，为空时为 local
+	Dir  string // 本地存储的根目录
+	S3   S3Options
+}
+
+// Open 根据配置创建存储
+func Open(opts Options) (Storage, error) {
+	switch opts.Type {
+	case "", TypeLocal:
+		return NewLocal(opts.Dir)
+	case TypeS3:
+		return NewS3(opts.S3)
+	case TypeMemory:
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", opts.Type)
+	}
+}
+
+// Rel 返回 s 的根目录相对于 base 根目录的路径，以 / 开头，不在 base 之下时返回 false
+func Rel(base, s Storage) (string, bool) {
+	root, sub := base.Root(), s.Root()
+	if sub == root {
+		return "/", true
+	}
+	rel, ok := strings.CutPrefix(sub, strings.TrimSuffix(root, "/")+"/")
+	if !ok {
+		return "", false
+	}
+	return "/" + rel, true
+}
+
+// MkdirAll 逐级创建目录
+func MkdirAll(ctx context.Context, fs webdav.FileSystem, name string) error {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return nil
+	}
+	if info, err := fs.Stat(ctx, name); err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+		}
+		return nil
+	}
+	if err := MkdirAll(ctx, fs, path.Dir(name)); err != nil {
+		return err
+	}
+	if err := fs.Mkdir(ctx, name, 0o755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Local 本地目录
+type Local struct {
+	webdav.Dir
+	dir string // 绝对路径
+}
+
+// NewLocal 创建以本地目录 dir 为根目录的存储，目录不存在时不创建
+func NewLocal(dir string) (*Local, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{Dir: webdav.Dir(dir), dir: dir}, nil
+}
+
+// Root 返回根目录的绝对路径
+func (l *Local) Root() string {
+	return filepath.ToSlash(l.dir)
+}
+
+// Sub 返回子目录的存储，dir 为绝对路径时直接使用该目录
+func (l *Local) Sub(_ context.Context, dir string) (Storage, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(l.dir, dir)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return NewLocal(dir)
+}
+
+// Memory 内存中的存储，重启后数据丢失
+type Memory struct {
+	webdav.FileSystem
+}
+
+// NewMemory 创建内存存储
+func NewMemory() *Memory {
+	return &Memory{FileSystem: webdav.NewMemFS()}
+}
+
+// Root 返回 memory:
+func (m *Memory) Root() string {
+	return TypeMemory + ":"
+}
+
+// Sub 返回子目录的存储
+func (m *Memory) Sub(ctx context.Context, dir string) (Storage, error) {
+	return newSubStorage(ctx, m, dir)
+}
+
+// 以上级存储中的目录为根目录的存储
+type subStorage struct {
+	parent Storage
+	dir    string // 以 / 开头
+}
+
+func newSubStorage(ctx context.Context, parent Storage, dir string) (Storage, error) {
+	dir = path.Clean("/" + filepath.ToSlash(dir))
+	if dir == "/" {
+		return parent, nil
+	}
+	if err := MkdirAll(ctx, parent, dir); err != nil {
+		return nil, err
+	}
+	return &subStorage{parent: parent, dir: dir}, nil
+}
+
+func (s *subStorage) join(name string) string {
+	return path.Join(s.dir, path.Clean("/"+name))
+}
+
+func (s *subStorage) Root() string {
+	return strings.TrimSuffix(s.parent.Root(), "/") + s.dir
+}
+
+func (s *subStorage) Sub(ctx context.Context, dir string) (Storage, error) {
+	return newSubStorage(ctx, s.parent, s.join(filepath.ToSlash(dir)))
+}
+
+func (s *subStorage) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return s.parent.Mkdir(ctx, s.join(name), perm)
+}
+
+func (s *subStorage) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	return s.parent.OpenFile(ctx, s.join(name), flag, perm)
+}
+
+func (s *subStorage) RemoveAll(ctx context.Context, name string) error {
+	if path.Clean("/"+name) == "/" {
+		// 与 webdav.Dir 一致，不允许删除根目录
+		return os.ErrInvalid
+	}
+	return s.parent.RemoveAll(ctx, s.join(name))
+}
+
+func (s *subStorage) Rename(ctx context.Context, oldName, newName string) error {
+	if path.Clean("/"+oldName) == "/" || path.Clean("/"+newName) == "/" {
+		return os.ErrInvalid
+	}
+	return s.parent.Rename(ctx, s.join(oldName), s.join(newName))
+}
+
+func (s *subStorage) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return s.parent.Stat(ctx, s.join(name))
+}
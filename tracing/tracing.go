@@ -0,0 +1,183 @@
+package tracing
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+
+	"github.com/junlongzzz/file-download-agent/common"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/junlongzzz/file-download-agent"
+
+// Options 链路追踪配置
+type Options struct {
+	Endpoint    string  // OTLP/HTTP 接收地址，如 http://localhost:4318
+	ServiceName string  // 服务名称
+	SampleRatio float64 // 采样比例，0~1
+}
+
+// Setup 初始化全局 TracerProvider 与传播器，返回用于刷新并关闭导出器的函数
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(opts.Endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("create otlp exporter: %w", err)
+	}
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(
+			semconv.ServiceName(opts.ServiceName),
+			semconv.ServiceVersion(common.Version()),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create trace resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// 上游已采样的请求保持采样，其余按比例采样
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Tracer 返回全局 Tracer，未初始化时为空实现
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Error 记录错误并将 span 标记为失败
+func Error(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Inject 将当前链路上下文写入上游请求头
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// StartUpstream 创建访问上游的客户端 span，url 中的凭证与查询参数已隐藏
+func StartUpstream(ctx context.Context, method string, u *url.URL) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, "upstream "+method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.HTTPRequestMethodKey.String(method),
+		semconv.ServerAddress(u.Hostname()),
+		semconv.URLFull(common.RedactURL(u.String())),
+	))
+}
+
+// SetStatusCode 记录上游响应状态码，4xx 与 5xx 标记为失败
+func SetStatusCode(span trace.Span, code int) {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+	if code >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(code))
+	}
+}
+
+// Middleware 为每个请求创建服务端 span，并从请求头中继承上游的链路上下文
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := Tracer().Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			semconv.ClientAddress(common.GetRealIP(r)),
+			semconv.UserAgentOriginal(r.UserAgent()),
+		))
+		defer span.End()
+
+		rec := common.NewResponseRecorder(w)
+		sr := r.WithContext(ctx)
+		next.ServeHTTP(rec, sr)
+		// ServeMux 在副本上设置了匹配的路由，回写给外层中间件使用
+		r.Pattern = sr.Pattern
+
+		if r.Pattern != "" {
+			span.SetName(r.Method + " " + r.Pattern)
+			span.SetAttributes(semconv.HTTPRoute(r.Pattern))
+		}
+		status := rec.Status()
+		span.SetAttributes(
+			semconv.HTTPResponseStatusCode(status),
+			attribute.Int64("http.response.body.size", rec.Written()),
+		)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// ClientTrace 返回将上游请求各阶段（DNS、建立连接、TLS握手、首字节）记录为子 span 的 httptrace
+func ClientTrace(ctx context.Context) *httptrace.ClientTrace {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.IsRecording() {
+		return &httptrace.ClientTrace{}
+	}
+	// 各阶段的回调可能在不同的 goroutine 中执行
+	var mu sync.Mutex
+	spans := make(map[string]trace.Span)
+	start := func(name string, attrs ...attribute.KeyValue) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, spans[name] = Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+	}
+	end := func(name string, err error, attrs ...attribute.KeyValue) {
+		mu.Lock()
+		defer mu.Unlock()
+		if span, ok := spans[name]; ok {
+			span.SetAttributes(attrs...)
+			if err != nil {
+				Error(span, err)
+			}
+			span.End()
+			delete(spans, name)
+		}
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			start("dns", attribute.String("net.host.name", info.Host))
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			end("dns", info.Err)
+		},
+		ConnectStart: func(network, addr string) {
+			start("connect "+addr, attribute.String("network.transport", network))
+		},
+		ConnectDone: func(network, addr string, err error) {
+			end("connect "+addr, err)
+		},
+		TLSHandshakeStart: func() {
+			start("tls")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			end("tls", err, attribute.String("tls.protocol.version", tls.VersionName(state.Version)))
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			parent.AddEvent("got_conn", trace.WithAttributes(attribute.Bool("reused", info.Reused)))
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			parent.AddEvent("wrote_request")
+		},
+		GotFirstResponseByte: func() {
+			parent.AddEvent("first_byte")
+		},
+	}
+}
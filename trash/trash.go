@@ -0,0 +1,452 @@
+package trash
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// Dir 回收站目录，位于用户根目录下
+const Dir = ".trash"
+
+// 清理过期项目的间隔
+const purgeInterval = time.Hour
+
+var (
+	ErrNotFound = errors.New("trash item not found")
+	ErrExists   = errors.New("original path already exists")
+)
+
+// Item 回收站中的文件或目录，内容保存在 .trash/<id>，信息保存在 .trash/<id>.json
+type Item struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`           // 原路径，相对于用户根目录
+	User      string    `json:"user,omitempty"` // 删除或覆盖的用户
+	Method    string    `json:"method"`         // 删除或覆盖时的请求方法
+	DeletedAt time.Time `json:"deleted_at"`
+	Size      int64     `json:"size"` // 所有文件的总字节数
+	Dir       bool      `json:"dir"`
+}
+
+// Bin 一个根目录的回收站，通过 webdav.FileSystem 读写，文件移入回收站后仍计入配额
+type Bin struct {
+	mu sync.Mutex // 串行恢复与删除
+	fs webdav.FileSystem
+}
+
+// NewBin 创建保存在 fs 根目录下的回收站
+func NewBin(fs webdav.FileSystem) *Bin {
+	return &Bin{fs: fs}
+}
+
+// Put 将 name 移入回收站
+func (b *Bin) Put(ctx context.Context, name, user, method string) (*Item, error) {
+	name = path.Clean("/" + name)
+	if name == "/" || IsTrashPath(name) {
+		return nil, os.ErrInvalid
+	}
+	info, err := b.fs.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	size, err := walkSize(ctx, b.fs, name, info)
+	if err != nil {
+		return nil, err
+	}
+	item := &Item{
+		ID:        newID(),
+		Path:      name,
+		User:      user,
+		Method:    method,
+		DeletedAt: time.Now(),
+		Size:      size,
+		Dir:       info.IsDir(),
+	}
+	if err = b.fs.Mkdir(ctx, "/"+Dir, 0o700); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	// 先写入信息，移动失败时删除
+	if err = writeItem(ctx, b.fs, item); err != nil {
+		return nil, err
+	}
+	if err = b.fs.Rename(ctx, name, contentPath(item.ID)); err != nil {
+		_ = b.fs.RemoveAll(ctx, itemPath(item.ID))
+		return nil, err
+	}
+	return item, nil
+}
+
+// List 按删除时间倒序返回回收站中的项目
+func (b *Bin) List(ctx context.Context) ([]Item, error) {
+	f, err := b.fs.OpenFile(ctx, "/"+Dir, os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return []Item{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	children, err := f.Readdir(-1)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(children)/2)
+	for _, child := range children {
+		id, ok := strings.CutSuffix(child.Name(), ".json")
+		if !ok || !validID(id) {
+			continue
+		}
+		item, err := readItem(ctx, b.fs, id)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Read trash item %s error: %v", id, err))
+			continue
+		}
+		items = append(items, *item)
+	}
+	slices.SortFunc(items, func(a, b Item) int {
+		return b.DeletedAt.Compare(a.DeletedAt)
+	})
+	return items, nil
+}
+
+// Get 返回回收站中的项目
+func (b *Bin) Get(ctx context.Context, id string) (*Item, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	return readItem(ctx, b.fs, id)
+}
+
+// Restore 将项目移回原路径，原路径已存在时返回 ErrExists，缺少的上级目录会被创建
+func (b *Bin) Restore(ctx context.Context, id string) (*Item, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	item, err := readItem(ctx, b.fs, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = b.fs.Stat(ctx, item.Path); err == nil {
+		return nil, ErrExists
+	}
+	if err = mkdirAll(ctx, b.fs, path.Dir(item.Path)); err != nil {
+		return nil, err
+	}
+	if err = b.fs.Rename(ctx, contentPath(id), item.Path); err != nil {
+		return nil, err
+	}
+	_ = b.fs.RemoveAll(ctx, itemPath(id))
+	return item, nil
+}
+
+// Delete 永久删除回收站中的项目
+func (b *Bin) Delete(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !validID(id) {
+		return ErrNotFound
+	}
+	if _, err := b.fs.Stat(ctx, itemPath(id)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if err := b.fs.RemoveAll(ctx, contentPath(id)); err != nil {
+		return err
+	}
+	return b.fs.RemoveAll(ctx, itemPath(id))
+}
+
+// Purge 永久删除 before 之前移入回收站的项目，返回删除的数量
+func (b *Bin) Purge(ctx context.Context, before time.Time) (int, error) {
+	items, err := b.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, item := range items {
+		if item.DeletedAt.Before(before) {
+			if err = b.Delete(ctx, item.ID); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// Entry 管理接口返回的项目，附带所属的根目录
+type Entry struct {
+	Root string `json:"root"` // 实际根目录
+	Item
+}
+
+// Manager 管理所有根目录的回收站，定期清理超过保留时长的项目
+type Manager struct {
+	retention atomic.Int64
+
+	mu   sync.Mutex
+	bins map[string]*Bin // 实际根目录 -> 回收站
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewManager 创建Manager，retention 为 0 时不清理
+func NewManager(retention time.Duration) *Manager {
+	m := &Manager{bins: make(map[string]*Bin), done: make(chan struct{})}
+	m.retention.Store(int64(retention))
+	m.wg.Add(1)
+	go m.purgeLoop()
+	return m
+}
+
+// SetRetention 设置保留时长，0 表示不清理
+func (m *Manager) SetRetention(retention time.Duration) {
+	m.retention.Store(int64(retention))
+}
+
+// SetBins 替换全部回收站，bins 为实际根目录 -> 回收站
+func (m *Manager) SetBins(bins map[string]*Bin) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bins = maps.Clone(bins)
+	if m.bins == nil {
+		m.bins = make(map[string]*Bin)
+	}
+}
+
+// 按根目录排序的回收站
+func (m *Manager) sortedBins() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	roots := make([]string, 0, len(m.bins))
+	for root := range m.bins {
+		roots = append(roots, root)
+	}
+	slices.Sort(roots)
+	return roots
+}
+
+func (m *Manager) bin(root string) *Bin {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bins[root]
+}
+
+// List 返回所有回收站中的项目，按删除时间倒序
+func (m *Manager) List(ctx context.Context) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	for _, root := range m.sortedBins() {
+		items, err := m.bin(root).List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", root, err)
+		}
+		for _, item := range items {
+			entries = append(entries, Entry{Root: root, Item: item})
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b Entry) int {
+		return b.DeletedAt.Compare(a.DeletedAt)
+	})
+	return entries, nil
+}
+
+// 查找项目所在的回收站
+func (m *Manager) find(ctx context.Context, id string) (string, *Bin, error) {
+	for _, root := range m.sortedBins() {
+		bin := m.bin(root)
+		if _, err := bin.Get(ctx, id); err == nil {
+			return root, bin, nil
+		} else if !errors.Is(err, ErrNotFound) {
+			return "", nil, err
+		}
+	}
+	return "", nil, ErrNotFound
+}
+
+// Restore 恢复项目
+func (m *Manager) Restore(ctx context.Context, id string) (*Entry, error) {
+	root, bin, err := m.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	item, err := bin.Restore(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{Root: root, Item: *item}, nil
+}
+
+// Delete 永久删除项目
+func (m *Manager) Delete(ctx context.Context, id string) error {
+	_, bin, err := m.find(ctx, id)
+	if err != nil {
+		return err
+	}
+	return bin.Delete(ctx, id)
+}
+
+// Close 停止定期清理
+func (m *Manager) Close() error {
+	if m == nil {
+		return nil
+	}
+	close(m.done)
+	m.wg.Wait()
+	return nil
+}
+
+func (m *Manager) purgeLoop() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.purge()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *Manager) purge() {
+	retention := time.Duration(m.retention.Load())
+	if retention <= 0 {
+		return
+	}
+	before := time.Now().Add(-retention)
+	for _, root := range m.sortedBins() {
+		purged, err := m.bin(root).Purge(context.Background(), before)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Purge trash in %s error: %v", root, err))
+		}
+		if purged > 0 {
+			slog.Info(fmt.Sprintf("Purged %d trash items in %s", purged, root))
+		}
+	}
+}
+
+// IsTrashPath 判断路径是否位于回收站目录
+func IsTrashPath(name string) bool {
+	name = path.Clean("/" + name)
+	return name == "/"+Dir || strings.HasPrefix(name, "/"+Dir+"/")
+}
+
+func contentPath(id string) string {
+	return path.Join("/", Dir, id)
+}
+
+func itemPath(id string) string {
+	return path.Join("/", Dir, id+".json")
+}
+
+func readItem(ctx context.Context, fs webdav.FileSystem, id string) (*Item, error) {
+	f, err := fs.OpenFile(ctx, itemPath(id), os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var item Item
+	if err = json.NewDecoder(f).Decode(&item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func writeItem(ctx context.Context, fs webdav.FileSystem, item *Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	f, err := fs.OpenFile(ctx, itemPath(item.ID), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 文件或目录下所有文件的总字节数
+func walkSize(ctx context.Context, fs webdav.FileSystem, name string, info os.FileInfo) (int64, error) {
+	if !info.IsDir() {
+		return info.Size(), nil
+	}
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	children, err := f.Readdir(-1)
+	_ = f.Close()
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	var size int64
+	for _, child := range children {
+		n, err := walkSize(ctx, fs, path.Join(name, child.Name()), child)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	return size, nil
+}
+
+// 逐级创建目录
+func mkdirAll(ctx context.Context, fs webdav.FileSystem, name string) error {
+	if name == "/" {
+		return nil
+	}
+	if info, err := fs.Stat(ctx, name); err == nil {
+		if !info.IsDir() {
+			return ErrExists
+		}
+		return nil
+	}
+	if err := mkdirAll(ctx, fs, path.Dir(name)); err != nil {
+		return err
+	}
+	if err := fs.Mkdir(ctx, name, 0o755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 随机项目ID
+func newID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ID 只能是 newID 生成的格式，避免拼接路径时越出回收站目录
+func validID(id string) bool {
+	if len(id) != 24 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,192 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func writeFile(t *testing.T, fs webdav.FileSystem, name, content string) {
+	t.Helper()
+	f, err := fs.OpenFile(context.Background(), name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.WriteString(f, content); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, fs webdav.FileSystem, name string) string {
+	t.Helper()
+	f, err := fs.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestPutRestore(t *testing.T) {
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	if err := fs.Mkdir(ctx, "/docs", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/docs/a.txt", "hello")
+	writeFile(t, fs, "/docs/b.txt", "world!")
+	bin := NewBin(fs)
+
+	item, err := bin.Put(ctx, "docs/a.txt", "alice", "DELETE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Path != "/docs/a.txt" || item.User != "alice" || item.Method != "DELETE" || item.Size != 5 || item.Dir {
+		t.Errorf("item = %+v", item)
+	}
+	if _, err = fs.Stat(ctx, "/docs/a.txt"); !os.IsNotExist(err) {
+		t.Fatalf("stat trashed file error = %v, want not exist", err)
+	}
+
+	// 整个目录移入回收站，大小为所有文件之和
+	dir, err := bin.Put(ctx, "/docs", "alice", "DELETE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dir.Dir || dir.Size != 6 {
+		t.Errorf("dir item = %+v, want dir of size 6", dir)
+	}
+	items, err := bin.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("items = %+v, want 2", items)
+	}
+	if got, err := bin.Get(ctx, item.ID); err != nil || got.Path != "/docs/a.txt" {
+		t.Errorf("Get = %+v, %v", got, err)
+	}
+
+	// 上级目录已被删除，恢复时重新创建
+	if _, err = bin.Restore(ctx, item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, "/docs/a.txt"); got != "hello" {
+		t.Errorf("restored content = %q, want hello", got)
+	}
+	// 原路径已存在时不覆盖
+	if _, err = bin.Restore(ctx, dir.ID); !errors.Is(err, ErrExists) {
+		t.Errorf("restore onto existing path error = %v, want ErrExists", err)
+	}
+	if _, err = bin.Restore(ctx, item.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restore twice error = %v, want ErrNotFound", err)
+	}
+	if items, _ = bin.List(ctx); len(items) != 1 || items[0].ID != dir.ID {
+		t.Errorf("items after restore = %+v, want only the dir", items)
+	}
+}
+
+func TestPutInvalid(t *testing.T) {
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	bin := NewBin(fs)
+	for _, name := range []string{"/", "/" + Dir, "/" + Dir + "/x"} {
+		if _, err := bin.Put(ctx, name, "", "DELETE"); !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("Put(%s) error = %v, want ErrInvalid", name, err)
+		}
+	}
+	if _, err := bin.Put(ctx, "/missing", "", "DELETE"); !os.IsNotExist(err) {
+		t.Errorf("Put missing error = %v, want not exist", err)
+	}
+	// ID 不能用于拼接回收站之外的路径
+	for _, id := range []string{"../x", "", "zzzzzzzzzzzzzzzzzzzzzzzz"} {
+		if _, err := bin.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) error = %v, want ErrNotFound", id, err)
+		}
+	}
+}
+
+func TestDeletePurge(t *testing.T) {
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	bin := NewBin(fs)
+	var ids []string
+	for _, name := range []string{"/a", "/b", "/c"} {
+		writeFile(t, fs, name, name)
+		item, err := bin.Put(ctx, name, "", "DELETE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, item.ID)
+	}
+
+	if err := bin.Delete(ctx, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := bin.Delete(ctx, ids[0]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete twice error = %v, want ErrNotFound", err)
+	}
+	// 内容与信息都被删除
+	if _, err := fs.Stat(ctx, contentPath(ids[0])); !os.IsNotExist(err) {
+		t.Errorf("stat deleted content error = %v, want not exist", err)
+	}
+
+	if n, err := bin.Purge(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("purge old items = %d, %v, want 0", n, err)
+	}
+	if n, err := bin.Purge(ctx, time.Now().Add(time.Second)); err != nil || n != 2 {
+		t.Errorf("purge all items = %d, %v, want 2", n, err)
+	}
+	if items, err := bin.List(ctx); err != nil || len(items) != 0 {
+		t.Errorf("items after purge = %+v, %v, want none", items, err)
+	}
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(0)
+	defer m.Close()
+	alice, bob := webdav.NewMemFS(), webdav.NewMemFS()
+	m.SetBins(map[string]*Bin{"/root/alice": NewBin(alice), "/root/bob": NewBin(bob)})
+
+	writeFile(t, bob, "/b.txt", "b")
+	item, err := m.bin("/root/bob").Put(ctx, "/b.txt", "bob", "PUT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := m.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Root != "/root/bob" || entries[0].ID != item.ID {
+		t.Fatalf("entries = %+v, want the item in /root/bob", entries)
+	}
+	entry, err := m.Restore(ctx, item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Root != "/root/bob" || readFile(t, bob, "/b.txt") != "b" {
+		t.Errorf("restored entry = %+v", entry)
+	}
+	if err = m.Delete(ctx, item.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete restored item error = %v, want ErrNotFound", err)
+	}
+
+	// 替换回收站后不再管理旧的回收站
+	m.SetBins(nil)
+	if entries, err = m.List(ctx); err != nil || len(entries) != 0 {
+		t.Errorf("entries after SetBins(nil) = %+v, %v, want none", entries, err)
+	}
+}
@@ -0,0 +1,282 @@
+package versions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// Dir 历史版本目录，位于用户根目录下
+// 文件 /a/b.txt 的版本保存为 .versions/a/b.txt@v<版本号>@<保存时间戳>@<修改时间戳>
+// 最后分配的版本号记录在 .versions/a/b.txt@seq 中
+const Dir = ".versions"
+
+// 记录最后分配的版本号的文件后缀
+const seqSuffix = "@seq"
+
+// ErrNotFound 版本不存在
+var ErrNotFound = errors.New("version not found")
+
+// Version 文件的一个历史版本
+type Version struct {
+	Number  int       `json:"version"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"` // 内容的最后修改时间
+	SavedAt time.Time `json:"saved_at"` // 被覆盖的时间
+
+	name string // 版本文件名
+}
+
+// Store 一个根目录下所有文件的历史版本，通过 webdav.FileSystem 读写
+type Store struct {
+	mu     sync.Mutex // 分配版本号时加锁，共用根目录的用户共用同一个 Store
+	fs     webdav.FileSystem
+	root   string        // 所在目录，以 / 开头
+	keep   int           // 保留的版本数，0 表示不限制
+	maxAge time.Duration // 保留时长，0 表示不限制
+}
+
+// NewStore 创建Store，keep 与 maxAge 同时设置时超出任一限制的版本被删除
+func NewStore(fs webdav.FileSystem, keep int, maxAge time.Duration) *Store {
+	return &Store{fs: fs, root: "/", keep: keep, maxAge: maxAge}
+}
+
+// Save 将文件当前内容复制为新版本，文件本身保持不变，之后按保留规则删除旧版本
+func (s *Store) Save(ctx context.Context, name string) (*Version, error) {
+	name = path.Clean("/" + name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := s.list(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	src, err := s.fs.OpenFile(ctx, path.Join(s.root, name), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer func(src webdav.File) {
+		_ = src.Close()
+	}(src)
+	info, err := src.Stat()
+	if err != nil {
+		return nil, err
+	}
+	dir := s.dir(name)
+	if err = mkdirAll(ctx, s.fs, dir); err != nil {
+		return nil, err
+	}
+	number, err := s.next(ctx, name, list)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	v := &Version{
+		Number:  number,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		SavedAt: now,
+		// 复制后的文件修改时间为当前时间，内容的修改时间记录在文件名中
+		name: fmt.Sprintf("%s@v%d@%d@%d", path.Base(name), number, now.Unix(), info.ModTime().Unix()),
+	}
+	if err = copyFile(ctx, s.fs, src, path.Join(dir, v.name)); err != nil {
+		return nil, err
+	}
+	s.prune(ctx, name, append([]Version{*v}, list...), now)
+	return v, nil
+}
+
+// 分配文件的下一个版本号，旧版本被删除后版本号也不会重复
+func (s *Store) next(ctx context.Context, name string, list []Version) (int, error) {
+	last := 0
+	if len(list) > 0 {
+		last = list[0].Number
+	}
+	seq := path.Join(s.dir(name), path.Base(name)+seqSuffix)
+	f, err := s.fs.OpenFile(ctx, seq, os.O_RDONLY, 0)
+	if err == nil {
+		content, err := io.ReadAll(io.LimitReader(f, 32))
+		_ = f.Close()
+		if err != nil {
+			return 0, err
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil {
+			last = max(last, n)
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+	number := last + 1
+	if f, err = s.fs.OpenFile(ctx, seq, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644); err != nil {
+		return 0, err
+	}
+	_, err = io.WriteString(f, strconv.Itoa(number))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return number, err
+}
+
+// List 按版本号倒序返回文件的历史版本，不包含已超出保留时长的版本
+func (s *Store) List(ctx context.Context, name string) ([]Version, error) {
+	name = path.Clean("/" + name)
+	list, err := s.list(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.prune(ctx, name, list, time.Now()), nil
+}
+
+// Open 打开文件的指定版本
+func (s *Store) Open(ctx context.Context, name string, number int) (webdav.File, *Version, error) {
+	name = path.Clean("/" + name)
+	list, err := s.List(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	i := slices.IndexFunc(list, func(v Version) bool { return v.Number == number })
+	if i < 0 {
+		return nil, nil, ErrNotFound
+	}
+	f, err := s.fs.OpenFile(ctx, path.Join(s.dir(name), list[i].name), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, &list[i], nil
+}
+
+// Find 在 name 的各级上级目录中查找版本目录并打开指定版本，用于不知道用户根目录的场景
+func Find(ctx context.Context, fs webdav.FileSystem, name string, number int) (webdav.File, *Version, error) {
+	name = path.Clean("/" + name)
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		s := &Store{fs: fs, root: dir}
+		rel := strings.TrimPrefix(name, strings.TrimSuffix(dir, "/"))
+		if f, v, err := s.Open(ctx, rel, number); err == nil {
+			return f, v, nil
+		} else if !errors.Is(err, ErrNotFound) {
+			return nil, nil, err
+		}
+		if dir == "/" {
+			return nil, nil, ErrNotFound
+		}
+	}
+}
+
+// IsVersionsPath 判断路径是否位于版本目录
+func IsVersionsPath(name string) bool {
+	name = path.Clean("/" + name)
+	return name == "/"+Dir || strings.HasPrefix(name, "/"+Dir+"/")
+}
+
+// 文件的版本所在目录
+func (s *Store) dir(name string) string {
+	return path.Join(s.root, Dir, path.Dir(name))
+}
+
+// 按版本号倒序返回文件的所有版本
+func (s *Store) list(ctx context.Context, name string) ([]Version, error) {
+	f, err := s.fs.OpenFile(ctx, s.dir(name), os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	children, err := f.Readdir(-1)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	prefix := path.Base(name) + "@v"
+	var list []Version
+	for _, child := range children {
+		rest, ok := strings.CutPrefix(child.Name(), prefix)
+		if !ok || !child.Mode().IsRegular() {
+			continue
+		}
+		number, saved, ok := strings.Cut(rest, "@")
+		if !ok {
+			continue
+		}
+		// 旧版本移动保存的文件名中没有修改时间，使用文件自身的修改时间
+		modTime := child.ModTime()
+		saved, modified, hasModified := strings.Cut(saved, "@")
+		if hasModified {
+			mt, err := strconv.ParseInt(modified, 10, 64)
+			if err != nil {
+				continue
+			}
+			modTime = time.Unix(mt, 0)
+		}
+		n, err1 := strconv.Atoi(number)
+		ts, err2 := strconv.ParseInt(saved, 10, 64)
+		if err1 != nil || err2 != nil || n <= 0 {
+			continue
+		}
+		list = append(list, Version{
+			Number:  n,
+			Size:    child.Size(),
+			ModTime: modTime,
+			SavedAt: time.Unix(ts, 0),
+			name:    child.Name(),
+		})
+	}
+	slices.SortFunc(list, func(a, b Version) int {
+		return b.Number - a.Number
+	})
+	return list, nil
+}
+
+// 删除超出保留数量或保留时长的版本，返回保留的版本
+func (s *Store) prune(ctx context.Context, name string, list []Version, now time.Time) []Version {
+	kept := list[:0:0]
+	for i, v := range list {
+		if (s.keep > 0 && i >= s.keep) || (s.maxAge > 0 && now.Sub(v.SavedAt) > s.maxAge) {
+			_ = s.fs.RemoveAll(ctx, path.Join(s.dir(name), v.name))
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return kept
+}
+
+// 逐级创建目录
+func mkdirAll(ctx context.Context, fs webdav.FileSystem, name string) error {
+	if name == "/" {
+		return nil
+	}
+	if _, err := fs.Stat(ctx, name); err == nil {
+		return nil
+	}
+	if err := mkdirAll(ctx, fs, path.Dir(name)); err != nil {
+		return err
+	}
+	if err := fs.Mkdir(ctx, name, 0o755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 将 src 的内容复制到新文件 name，失败时删除不完整的文件
+func copyFile(ctx context.Context, fs webdav.FileSystem, src io.Reader, name string) error {
+	dst, err := fs.OpenFile(ctx, name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = fs.RemoveAll(ctx, name)
+	}
+	return err
+}
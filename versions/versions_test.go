@@ -0,0 +1,171 @@
+package versions
+
+import (
+	"context"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func writeFile(t *testing.T, fs webdav.FileSystem, name, content string) {
+	t.Helper()
+	f, err := fs.OpenFile(context.Background(), name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.WriteString(f, content); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readVersion(t *testing.T, s *Store, name string, number int) string {
+	t.Helper()
+	f, _, err := s.Open(context.Background(), name, number)
+	if err != nil {
+		t.Fatalf("open %s version %d: %v", name, number, err)
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func numbers(list []Version) []int {
+	var ns []int
+	for _, v := range list {
+		ns = append(ns, v.Number)
+	}
+	return ns
+}
+
+func TestSaveKeepsFile(t *testing.T) {
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	if err := fs.Mkdir(ctx, "/docs", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/docs/a.txt", "v1")
+	s := NewStore(fs, 0, 0)
+
+	v, err := s.Save(ctx, "/docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Number != 1 || v.Size != 2 {
+		t.Errorf("saved version = %+v, want number 1 size 2", v)
+	}
+	// 保存版本不影响文件本身
+	if info, err := fs.Stat(ctx, "/docs/a.txt"); err != nil || info.Size() != 2 {
+		t.Fatalf("file after save = %v, %v, want unchanged", info, err)
+	}
+	if got := readVersion(t, s, "/docs/a.txt", 1); got != "v1" {
+		t.Errorf("version 1 = %q, want v1", got)
+	}
+
+	writeFile(t, fs, "/docs/a.txt", "v2")
+	if _, err = s.Save(ctx, "docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.List(ctx, "/docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(numbers(list), []int{2, 1}) {
+		t.Fatalf("versions = %v, want [2 1]", numbers(list))
+	}
+	if got := readVersion(t, s, "/docs/a.txt", 2); got != "v2" {
+		t.Errorf("version 2 = %q, want v2", got)
+	}
+	if _, _, err = s.Open(ctx, "/docs/a.txt", 3); err != ErrNotFound {
+		t.Errorf("open version 3 error = %v, want ErrNotFound", err)
+	}
+	// 其他文件的版本单独编号
+	writeFile(t, fs, "/docs/b.txt", "b")
+	if v, err = s.Save(ctx, "/docs/b.txt"); err != nil || v.Number != 1 {
+		t.Errorf("save b.txt = %+v, %v, want version 1", v, err)
+	}
+}
+
+func TestSavePrunesKeep(t *testing.T) {
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	s := NewStore(fs, 2, 0)
+	for _, content := range []string{"1", "2", "3", "4"} {
+		writeFile(t, fs, "/a.txt", content)
+		if _, err := s.Save(ctx, "/a.txt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := s.List(ctx, "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(numbers(list), []int{4, 3}) {
+		t.Fatalf("versions = %v, want [4 3]", numbers(list))
+	}
+	if got := readVersion(t, s, "/a.txt", 3); got != "3" {
+		t.Errorf("version 3 = %q, want 3", got)
+	}
+}
+
+// 所有版本都因过期被删除后，版本号继续递增而不是从 1 重新开始
+func TestSaveNumberIsMonotonicAfterPrune(t *testing.T) {
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	s := NewStore(fs, 0, time.Hour)
+	writeFile(t, fs, "/a.txt", "old")
+	if _, err := s.Save(ctx, "/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Save(ctx, "/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := &Store{fs: fs, root: "/", maxAge: time.Nanosecond}
+	time.Sleep(time.Millisecond)
+	if list, err := expired.List(ctx, "/a.txt"); err != nil || len(list) != 0 {
+		t.Fatalf("versions after expiry = %v, %v, want none", list, err)
+	}
+	v, err := s.Save(ctx, "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Number != 3 {
+		t.Errorf("version after prune = %d, want 3", v.Number)
+	}
+}
+
+func TestFind(t *testing.T) {
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	if err := fs.Mkdir(ctx, "/alice", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/alice/a.txt", "content")
+	// 用户根目录为 /alice
+	s := &Store{fs: fs, root: "/alice"}
+	if _, err := s.Save(ctx, "/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, v, err := Find(ctx, fs, "/alice/a.txt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if v.Number != 1 || v.Size != int64(len("content")) {
+		t.Errorf("found version = %+v, want number 1", v)
+	}
+	if _, _, err = Find(ctx, fs, "/alice/a.txt", 2); err != ErrNotFound {
+		t.Errorf("find version 2 error = %v, want ErrNotFound", err)
+	}
+}
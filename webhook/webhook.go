@@ -0,0 +1,254 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/junlongzzz/file-download-agent/common"
+)
+
+// EventType 事件类型
+type EventType string
+
+const (
+	DownloadStarted   EventType = "download.started"
+	DownloadCompleted EventType = "download.completed"
+	DownloadFailed    EventType = "download.failed"
+	LinkExpired       EventType = "link.expired"
+	SignatureRejected EventType = "signature.rejected"
+)
+
+// EventTypes 所有事件类型
+var EventTypes = []EventType{DownloadStarted, DownloadCompleted, DownloadFailed, LinkExpired, SignatureRejected}
+
+// 请求头
+const (
+	HeaderEvent     = "X-FDA-Event"
+	HeaderDelivery  = "X-FDA-Delivery"
+	HeaderTimestamp = "X-FDA-Timestamp"
+	HeaderSignature = "X-FDA-Signature" // sha256=hex(hmac_sha256(secret, timestamp + "." + body))
+)
+
+// 默认队列长度与 worker 数量
+const (
+	DefaultQueueSize = 1024
+	workers          = 4
+	maxBackoff       = time.Minute
+)
+
+// Event 推送给 webhook 的事件
+type Event struct {
+	ID         string    `json:"id"`
+	Type       EventType `json:"type"`
+	Time       time.Time `json:"time"`
+	LinkID     string    `json:"link_id,omitempty"`
+	Url        string    `json:"url,omitempty"` // 已隐藏凭证与查询参数
+	Filename   string    `json:"filename,omitempty"`
+	ClientIP   string    `json:"client_ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Status     int       `json:"status,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// Options webhook 配置
+type Options struct {
+	Urls       []string      // 接收事件的地址
+	Secret     string        // HMAC 签名密钥
+	Events     []EventType   // 需要推送的事件，为空时推送全部
+	MaxRetries int           // 失败后的最大重试次数
+	Timeout    time.Duration // 单次推送超时
+}
+
+// Notifier 异步推送事件到 webhook，队列满时丢弃事件，不阻塞调用方
+type Notifier struct {
+	queue   chan *Event
+	options atomic.Pointer[Options]
+	client  *http.Client
+
+	mu     sync.RWMutex // 保护 closed 与向 queue 发送
+	closed bool
+	wg     sync.WaitGroup
+	ctx    context.Context // 关闭超时后取消进行中的推送与重试
+	cancel context.CancelFunc
+}
+
+// NewNotifier 创建并启动推送队列
+func NewNotifier(queueSize int) *Notifier {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	n := &Notifier{
+		queue:  make(chan *Event, queueSize),
+		client: &http.Client{},
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	n.options.Store(&Options{})
+	for range workers {
+		n.wg.Add(1)
+		go n.worker()
+	}
+	return n
+}
+
+// SetOptions 更新 webhook 配置，已入队的事件使用推送时的配置
+func (n *Notifier) SetOptions(opts Options) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	n.options.Store(&opts)
+}
+
+// Notify 将事件加入推送队列，n 为空、未配置地址或事件未订阅时忽略
+func (n *Notifier) Notify(e Event) {
+	if n == nil {
+		return
+	}
+	opts := n.options.Load()
+	if len(opts.Urls) == 0 || (len(opts.Events) > 0 && !slices.Contains(opts.Events, e.Type)) {
+		return
+	}
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.queue <- &e:
+	default:
+		slog.Warn(fmt.Sprintf("Webhook queue is full, dropping event %s (%s)", e.ID, e.Type))
+	}
+}
+
+// Close 停止接收新事件，等待队列中的事件推送完成
+// ctx 结束时放弃剩余的推送
+func (n *Notifier) Close(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (n *Notifier) worker() {
+	defer n.wg.Done()
+	for e := range n.queue {
+		body, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		for _, u := range n.options.Load().Urls {
+			n.deliver(u, e, body)
+		}
+	}
+}
+
+// 推送事件到单个地址，失败时按指数退避重试
+func (n *Notifier) deliver(u string, e *Event, body []byte) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		opts := n.options.Load()
+		err := n.send(opts, u, e, body)
+		if err == nil {
+			return
+		}
+		if attempt >= opts.MaxRetries || n.ctx.Err() != nil {
+			slog.Error(fmt.Sprintf("Webhook delivery failed: %s - event %s (%s) after %d attempts: %v",
+				common.RedactURL(u), e.ID, e.Type, attempt+1, err))
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-n.ctx.Done():
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (n *Notifier) send(opts *Options, u string, e *Event, body []byte) error {
+	ctx, cancel := context.WithTimeout(n.ctx, opts.Timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "fda-webhook/"+common.Version())
+	request.Header.Set(HeaderEvent, string(e.Type))
+	request.Header.Set(HeaderDelivery, e.ID)
+	request.Header.Set(HeaderTimestamp, timestamp)
+	if opts.Secret != "" {
+		request.Header.Set(HeaderSignature, "sha256="+Sign(opts.Secret, timestamp, body))
+	}
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	_ = response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", response.Status)
+	}
+	return nil
+}
+
+// Sign 计算事件签名 hex(hmac_sha256(secret, timestamp + "." + body))
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ParseEventType 解析事件类型
+func ParseEventType(s string) (EventType, error) {
+	if t := EventType(s); slices.Contains(EventTypes, t) {
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown webhook event: %s", s)
+}
+
+// 随机事件ID
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}